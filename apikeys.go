@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// unauthorizedBench is how long a key rejected with 401 is skipped before being tried again
+const unauthorizedBench = 5 * time.Minute
+
+// redactedAPIKey stands in for an API key in URLs that are logged or shown to clients
+const redactedAPIKey = "REDACTED"
+
+// apiKeyPool hands out OpenWeatherMap API keys round-robin, skipping keys that were
+// recently rejected so one exhausted or revoked key doesn't fail every request
+type apiKeyPool struct {
+	keys []string
+
+	mu           sync.Mutex
+	next         int
+	benchedUntil []time.Time
+}
+
+// newAPIKeyPool creates a pool over keys
+func newAPIKeyPool(keys []string) *apiKeyPool {
+	return &apiKeyPool{
+		keys:         keys,
+		benchedUntil: make([]time.Time, len(keys)),
+	}
+}
+
+// size returns how many keys are configured
+func (p *apiKeyPool) size() int {
+	return len(p.keys)
+}
+
+// pick returns the next healthy key and its index. If every key is benched, the one that
+// recovers soonest is used rather than failing outright.
+func (p *apiKeyPool) pick(now time.Time) (int, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	soonest := p.next
+	for range p.keys {
+		i := p.next
+		p.next = (p.next + 1) % len(p.keys)
+		if !now.Before(p.benchedUntil[i]) {
+			return i, p.keys[i]
+		}
+		if p.benchedUntil[i].Before(p.benchedUntil[soonest]) {
+			soonest = i
+		}
+	}
+	return soonest, p.keys[soonest]
+}
+
+// bench skips the key at index i until now+d
+func (p *apiKeyPool) bench(i int, now time.Time, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.benchedUntil[i] = now.Add(d)
+}
+
+// keyFailure reports whether err means the key itself was refused, and for how long the
+// key should be skipped. The reason labels the apiKeysBenched metric.
+func keyFailure(err error) (reason string, bench time.Duration, ok bool) {
+	var rateLimited *RateLimitError
+	if errors.As(err, &rateLimited) {
+		return "rate_limited", rateLimited.RetryAfter, true
+	}
+	if errors.Is(err, ErrUnauthorized) {
+		return "unauthorized", unauthorizedBench, true
+	}
+	return "", 0, false
+}
+
+// redactAPIKey strips the appid parameter from the URL carried by a transport error, so a
+// key can't leak into logs or client-facing error messages
+func redactAPIKey(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	if u, parseErr := url.Parse(urlErr.URL); parseErr == nil {
+		q := u.Query()
+		if q.Has("appid") {
+			q.Set("appid", redactedAPIKey)
+			u.RawQuery = q.Encode()
+			urlErr.URL = u.String()
+		}
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// authProtectedPrefixes are the paths that spend upstream quota and so need a token when
+// API_TOKENS is set
+var authProtectedPrefixes = []string{"/predict", "/compare", "/heatmap", "/forecast", "/ws"}
+
+// tokenAuth checks bearer tokens against a fixed set. Tokens are stored hashed so each
+// comparison takes the same time whatever the token length.
+type tokenAuth struct {
+	hashes [][sha256.Size]byte
+}
+
+// newTokenAuth creates an authenticator accepting any of tokens
+func newTokenAuth(tokens []string) *tokenAuth {
+	a := &tokenAuth{}
+	for _, token := range tokens {
+		a.hashes = append(a.hashes, sha256.Sum256([]byte(token)))
+	}
+	return a
+}
+
+// valid reports whether token matches a configured token, in constant time
+func (a *tokenAuth) valid(token string) bool {
+	sum := sha256.Sum256([]byte(token))
+	match := 0
+	for _, hash := range a.hashes {
+		match |= subtle.ConstantTimeCompare(sum[:], hash[:])
+	}
+	return match == 1
+}
+
+// middleware rejects requests to protected paths without a valid Authorization: Bearer header
+func (a *tokenAuth) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authProtected(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || !a.valid(strings.TrimSpace(token)) {
+			log.FromContext(r.Context()).Warn("Rejected request without a valid API token", "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="rainbows"`)
+			writeJSONError(w, http.StatusUnauthorized, "A valid API token is required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authProtected reports whether path requires a token
+func authProtected(path string) bool {
+	for _, prefix := range authProtectedPrefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
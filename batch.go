@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/charmbracelet/log"
+)
+
+// BatchLocation is one coordinate in a batch prediction request
+type BatchLocation struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// BatchPredictionResult is the outcome for one location in a batch; exactly one of
+// Prediction and Error is set
+type BatchPredictionResult struct {
+	Lat        float64            `json:"lat"`
+	Lon        float64            `json:"lon"`
+	Prediction *RainbowPrediction `json:"prediction,omitempty"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// handleBatchPrediction predicts several locations and streams the results as a JSON array.
+// Locations are predicted in chunks of BatchChunkSize, each concurrently, and every chunk is
+// written as soon as it completes, so only one chunk of predictions is held at a time. Results
+// are always in request order, and a failure for one location is reported on that entry only.
+// Batches larger than MaxBatchSize are refused with 413.
+func (s *Server) handleBatchPrediction(w http.ResponseWriter, r *http.Request) {
+	logger := log.FromContext(r.Context())
+	if !acceptsJSON(w, r) {
+		return
+	}
+	opts, err := s.parsePredictOptions(r)
+	if err != nil {
+		logger.Error("Invalid prediction options", "error", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var locations []BatchLocation
+	if status, err := decodeJSONBody(r, &locations); err != nil {
+		logger.Error("Invalid batch request body", "error", err)
+		writeJSONError(w, status, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if len(locations) > s.config.MaxBatchSize {
+		logger.Error("Batch too large", "locations", len(locations), "max", s.config.MaxBatchSize)
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf(
+			"batch of %d locations exceeds the maximum of %d; split it into smaller requests", len(locations), s.config.MaxBatchSize))
+		return
+	}
+
+	predictionRequests.Add(float64(len(locations)))
+	logger.Info("Handling batch prediction request", "locations", len(locations), "options", opts)
+	setLanguageHeaders(w, opts.Language)
+
+	// The status is sent before any prediction is made; failures are reported per entry
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	sep := "["
+	for start := 0; start < len(locations); start += s.config.BatchChunkSize {
+		end := min(start+s.config.BatchChunkSize, len(locations))
+		for _, result := range s.predictLocations(r.Context(), locations[start:end], opts) {
+			data, err := json.Marshal(result)
+			if err != nil {
+				logger.Error("Error encoding batch result", "error", err)
+				return
+			}
+			if _, err := io.WriteString(w, sep); err != nil {
+				logger.Debug("Error writing batch response", "error", err)
+				return
+			}
+			if _, err := w.Write(data); err != nil {
+				logger.Debug("Error writing batch response", "error", err)
+				return
+			}
+			sep = ","
+		}
+		rc.Flush()
+	}
+	if sep == "[" {
+		io.WriteString(w, sep)
+	}
+	io.WriteString(w, "]\n")
+}
+
+// predictLocations predicts locations concurrently, at most BatchConcurrency at once, returning
+// the results in the order given
+func (s *Server) predictLocations(ctx context.Context, locations []BatchLocation, opts PredictOptions) []BatchPredictionResult {
+	logger := log.FromContext(ctx)
+	results := make([]BatchPredictionResult, len(locations))
+	for i, loc := range locations {
+		results[i] = BatchPredictionResult{Lat: loc.Lat, Lon: loc.Lon}
+	}
+	err := forEachBounded(ctx, len(locations), s.config.BatchConcurrency, func(i int) {
+		loc := locations[i]
+		if err := (Coordinates{Lat: loc.Lat, Lon: loc.Lon}).validate(); err != nil {
+			results[i].Error = err.Error()
+			return
+		}
+
+		prediction, err := s.predict(ctx, loc.Lat, loc.Lon, opts)
+		if err != nil {
+			logger.Error("Error fetching weather data", "error", err, "lat", loc.Lat, "lon", loc.Lon)
+			results[i].Error = fmt.Sprintf("Error fetching weather data: %v", err)
+			return
+		}
+		results[i].Prediction = &prediction
+	})
+	if err != nil {
+		// Locations never dispatched before the request was canceled are reported as such
+		for i := range results {
+			if results[i].Prediction == nil && results[i].Error == "" {
+				results[i].Error = fmt.Sprintf("Not predicted: %v", err)
+			}
+		}
+	}
+	return results
+}
@@ -0,0 +1,39 @@
+package main
+
+// Bow classifications reported alongside the numeric likelihood
+const (
+	bowNone    = "none"
+	bowPrimary = "primary"
+	bowDouble  = "double"
+)
+
+// Factor thresholds a secondary bow needs on top of a likely primary. The secondary bow
+// reflects light twice inside each drop, so it is much fainter and only shows with clean
+// air, plenty of large drops and strong, direct light.
+const (
+	doubleBowMinVisibility = 0.9
+	doubleBowMinHumidity   = 0.8
+	doubleBowMinRainScale  = 1.0
+	doubleBowMinLight      = 0.7
+)
+
+// classifyBow turns a scored hour into none, primary or double. It is kept apart from the
+// numeric score so the thresholds can be tuned without touching the model.
+func classifyBow(likely bool, breakdown Breakdown) string {
+	if !likely || !breakdown.Factors.Suitable {
+		return bowNone
+	}
+	factors := breakdown.Factors
+	// Moonlight is too weak to light a visible secondary bow
+	if breakdown.Kind == bowTypeMoonbow {
+		return bowPrimary
+	}
+	rainScale, _ := rainIntensity(factors.WeatherID)
+	if factors.VisibilityFactor >= doubleBowMinVisibility &&
+		factors.HumidityFactor >= doubleBowMinHumidity &&
+		rainScale >= doubleBowMinRainScale &&
+		factors.LightFactor >= doubleBowMinLight {
+		return bowDouble
+	}
+	return bowPrimary
+}
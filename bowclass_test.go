@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+// brightShower is a breakdown for light shower rain in clean, damp air under a strong sun,
+// which clears every double bow threshold
+func brightShower() Breakdown {
+	return Breakdown{Kind: bowTypeRainbow, Factors: LikelihoodFactors{
+		Suitable:         true,
+		WeatherID:        520,
+		VisibilityFactor: 1,
+		HumidityFactor:   0.9,
+		LightFactor:      0.8,
+	}}
+}
+
+func TestClassifyBow(t *testing.T) {
+	tests := []struct {
+		name   string
+		likely bool
+		adjust func(b *Breakdown)
+		want   string
+	}{
+		{name: "bright shower", likely: true, want: bowDouble},
+		{name: "below the threshold", likely: false, want: bowNone},
+		{name: "unsuitable weather", likely: true, adjust: func(b *Breakdown) { b.Factors.Suitable = false }, want: bowNone},
+		{name: "moonbow", likely: true, adjust: func(b *Breakdown) { b.Kind = bowTypeMoonbow }, want: bowPrimary},
+		{name: "hazy", likely: true, adjust: func(b *Breakdown) { b.Factors.VisibilityFactor = 0.89 }, want: bowPrimary},
+		{name: "dry air", likely: true, adjust: func(b *Breakdown) { b.Factors.HumidityFactor = 0.79 }, want: bowPrimary},
+		{name: "weak light", likely: true, adjust: func(b *Breakdown) { b.Factors.LightFactor = 0.69 }, want: bowPrimary},
+		{name: "moderate rain", likely: true, adjust: func(b *Breakdown) { b.Factors.WeatherID = 501 }, want: bowPrimary},
+		{name: "drizzle", likely: true, adjust: func(b *Breakdown) { b.Factors.WeatherID = 301 }, want: bowPrimary},
+		{name: "light rain at the rain threshold", likely: true, adjust: func(b *Breakdown) { b.Factors.WeatherID = 500 }, want: bowDouble},
+		{name: "every factor at its threshold", likely: true, adjust: func(b *Breakdown) {
+			b.Factors.VisibilityFactor = doubleBowMinVisibility
+			b.Factors.HumidityFactor = doubleBowMinHumidity
+			b.Factors.LightFactor = doubleBowMinLight
+		}, want: bowDouble},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breakdown := brightShower()
+			if tt.adjust != nil {
+				tt.adjust(&breakdown)
+			}
+			if got := classifyBow(tt.likely, breakdown); got != tt.want {
+				t.Errorf("classifyBow = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// States of a circuitBreaker, as reported by the upstreamCircuitState metric
+const (
+	breakerClosed = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+// breakerStateNames label circuit breaker states in logs
+var breakerStateNames = [...]string{
+	breakerClosed:   "closed",
+	breakerHalfOpen: "half-open",
+	breakerOpen:     "open",
+}
+
+// circuitBreaker stops calls to an upstream that keeps failing. After threshold consecutive
+// failures it opens and rejects calls for cooldown, then half-opens to let a single probe
+// through: a successful probe closes it again and a failed one reopens it. A nil breaker
+// lets every call through.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	clock     Clock
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// newCircuitBreaker creates a breaker, or returns nil when cooldown is zero to disable it
+func newCircuitBreaker(threshold int, cooldown time.Duration, clock Clock) *circuitBreaker {
+	if cooldown == 0 {
+		return nil
+	}
+	upstreamCircuitState.Set(breakerClosed)
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, clock: clock}
+}
+
+// allow returns a *CircuitOpenError if a call may not go ahead now. A call that is allowed
+// must be followed by record once it finishes.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		if wait := b.cooldown - b.clock.Now().Sub(b.openedAt); wait > 0 {
+			upstreamCircuitRejections.Inc()
+			return &CircuitOpenError{RetryAfter: wait}
+		}
+		b.setState(breakerHalfOpen)
+	}
+	if b.state == breakerHalfOpen {
+		if b.probing {
+			upstreamCircuitRejections.Inc()
+			return &CircuitOpenError{RetryAfter: b.cooldown}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record counts the outcome of an allowed call. Only an unreachable or failing upstream
+// counts against it; calls abandoned because ctx ended say nothing about the upstream.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasProbe := b.probing
+	b.probing = false
+	if ctx.Err() != nil {
+		return
+	}
+	if !errors.Is(err, ErrUpstreamUnavailable) {
+		b.failures = 0
+		if wasProbe {
+			b.setState(breakerClosed)
+		}
+		return
+	}
+	b.failures++
+	if wasProbe || b.failures >= b.threshold {
+		b.openedAt = b.clock.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+// setState moves the breaker to state, logging and publishing the change
+func (b *circuitBreaker) setState(state int) {
+	if b.state == state {
+		return
+	}
+	log.Warn("Upstream circuit breaker changed state",
+		"from", breakerStateNames[b.state], "to", breakerStateNames[state], "failures", b.failures)
+	b.state = state
+	upstreamCircuitState.Set(float64(state))
+}
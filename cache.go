@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// cacheEntry holds a cached weather response and when it stops being valid
+type cacheEntry struct {
+	data      WeatherData
+	expiresAt time.Time
+}
+
+// cacheWaiter is closed the next time its key is stored. subscribers counts the streams
+// still waiting on it, so it can be dropped once the last of them leaves.
+type cacheWaiter struct {
+	ch          chan struct{}
+	subscribers int
+}
+
+// CachingProvider wraps a WeatherProvider and reuses responses for nearby coordinates within a TTL
+type CachingProvider struct {
+	next WeatherProvider
+	ttl  time.Duration
+	// precision is how many decimal places coordinates are rounded to in cache keys
+	precision int
+	clock     Clock
+
+	mu        sync.Mutex
+	entries   map[string]cacheEntry
+	lastPrune time.Time
+	// waiters are closed the next time their key is stored, waking stream subscribers
+	waiters map[string]*cacheWaiter
+}
+
+// NewCachingProvider creates a cache in front of next that keeps entries for ttl, sharing
+// them between coordinates equal to precision decimal places
+func NewCachingProvider(next WeatherProvider, ttl time.Duration, precision int, clock Clock) *CachingProvider {
+	return &CachingProvider{
+		next:      next,
+		ttl:       ttl,
+		precision: precision,
+		clock:     clock,
+		entries:   make(map[string]cacheEntry),
+		waiters:   make(map[string]*cacheWaiter),
+	}
+}
+
+// CurrentAndHourly serves weather from the cache when fresh and falls through to the wrapped provider otherwise
+func (c *CachingProvider) CurrentAndHourly(ctx context.Context, lat, lon float64, opts FetchOptions) (WeatherData, error) {
+	logger := log.FromContext(ctx)
+	key := c.key(lat, lon, opts)
+	if data, ok := c.get(key); ok {
+		logger.Debug("Weather cache hit", "key", key)
+		return data, nil
+	}
+
+	logger.Debug("Weather cache miss", "key", key)
+	data, err := c.next.CurrentAndHourly(ctx, lat, lon, opts)
+	if err != nil {
+		return WeatherData{}, err
+	}
+	// A partial response is served once but not kept, so the next request tries again
+	if !data.Partial {
+		c.set(key, data)
+	}
+	return data, nil
+}
+
+// refresh fetches the coordinate from the wrapped provider and stores it, whether or not
+// the cached copy is still fresh
+func (c *CachingProvider) refresh(ctx context.Context, lat, lon float64, opts FetchOptions) error {
+	data, err := c.next.CurrentAndHourly(ctx, lat, lon, opts)
+	if err != nil {
+		return err
+	}
+	if !data.Partial {
+		c.set(c.key(lat, lon, opts), data)
+	}
+	return nil
+}
+
+// get returns the cached data for key if it has not expired
+func (c *CachingProvider) get(key string) (WeatherData, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return WeatherData{}, false
+	}
+	if c.clock.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return WeatherData{}, false
+	}
+	return entry.data, true
+}
+
+// set stores data under key and periodically drops expired entries so the map doesn't grow without bound
+func (c *CachingProvider) set(key string, data WeatherData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	c.entries[key] = cacheEntry{data: data, expiresAt: now.Add(c.ttl)}
+	if w, ok := c.waiters[key]; ok {
+		close(w.ch)
+		delete(c.waiters, key)
+	}
+
+	if now.Sub(c.lastPrune) < c.ttl {
+		return
+	}
+	for k, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.lastPrune = now
+}
+
+// refreshed returns a channel that is closed the next time fresh weather for the
+// coordinate is stored, whoever's request fetched it. The caller must call release once
+// it stops waiting, so a coordinate nobody fetches again doesn't keep its waiter forever.
+func (c *CachingProvider) refreshed(lat, lon float64, opts FetchOptions) (ch <-chan struct{}, release func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := c.key(lat, lon, opts)
+	w, ok := c.waiters[key]
+	if !ok {
+		w = &cacheWaiter{ch: make(chan struct{})}
+		c.waiters[key] = w
+	}
+	w.subscribers++
+	return w.ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		w.subscribers--
+		// The waiter may already have been closed and replaced by a newer one for the key
+		if w.subscribers == 0 && c.waiters[key] == w {
+			delete(c.waiters, key)
+		}
+	}
+}
+
+// upstreamURL describes the wrapped provider's request, since a dry run never reads the cache
+func (c *CachingProvider) upstreamURL(lat, lon float64, opts FetchOptions) (string, bool) {
+	describer, ok := c.next.(upstreamDescriber)
+	if !ok {
+		return "", false
+	}
+	return describer.upstreamURL(lat, lon, opts)
+}
+
+// key rounds coordinates to the cache's precision so nearby lookups share an entry. Two
+// decimal places is about 1.1km. The fetch options are part of the key since they change
+// the response.
+func (c *CachingProvider) key(lat, lon float64, opts FetchOptions) string {
+	return fmt.Sprintf("%.*f,%.*f,%s,%s", c.precision, lat, c.precision, lon, opts.Units, strings.Join(opts.Exclude, "+"))
+}
+
+// size returns how many entries are held, including expired ones not yet pruned
+func (c *CachingProvider) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingProvider returns fixed weather and counts how often it is asked. It is safe for
+// the concurrent fetches of a heatmap scan.
+type countingProvider struct {
+	data  WeatherData
+	calls atomic.Int64
+}
+
+func (p *countingProvider) CurrentAndHourly(ctx context.Context, lat, lon float64, opts FetchOptions) (WeatherData, error) {
+	p.calls.Add(1)
+	return p.data, nil
+}
+
+func TestCachingProviderExpiresAfterTTL(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	next := &countingProvider{data: WeatherData{Timezone: "UTC"}}
+	cache := NewCachingProvider(next, 10*time.Minute, 2, clock)
+	ctx := context.Background()
+	opts := FetchOptions{Units: unitsMetric}
+
+	fetch := func() {
+		t.Helper()
+		if _, err := cache.CurrentAndHourly(ctx, 51.5, -0.12, opts); err != nil {
+			t.Fatalf("CurrentAndHourly: %v", err)
+		}
+	}
+
+	fetch()
+	clock.Advance(9 * time.Minute)
+	fetch()
+	if next.calls.Load() != 1 {
+		t.Fatalf("upstream calls within TTL = %d, want 1", next.calls.Load())
+	}
+
+	clock.Advance(2 * time.Minute)
+	fetch()
+	if next.calls.Load() != 2 {
+		t.Fatalf("upstream calls after TTL = %d, want 2", next.calls.Load())
+	}
+}
+
+func TestCachingProviderSharesNearbyCoordinates(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	next := &countingProvider{}
+	cache := NewCachingProvider(next, time.Minute, 2, clock)
+	ctx := context.Background()
+
+	for _, lat := range []float64{51.501, 51.504} {
+		if _, err := cache.CurrentAndHourly(ctx, lat, -0.12, FetchOptions{Units: unitsMetric}); err != nil {
+			t.Fatalf("CurrentAndHourly: %v", err)
+		}
+	}
+	if next.calls.Load() != 1 {
+		t.Errorf("upstream calls = %d, want 1 for coordinates equal to two decimal places", next.calls.Load())
+	}
+}
+
+func TestCachingProviderDropsAbandonedWaiters(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	cache := NewCachingProvider(&countingProvider{}, time.Minute, 2, clock)
+	opts := FetchOptions{Units: unitsMetric}
+
+	// Two streams wait on the same coordinate; the waiter stays until both leave
+	_, releaseFirst := cache.refreshed(51.5, -0.12, opts)
+	_, releaseSecond := cache.refreshed(51.5, -0.12, opts)
+	releaseFirst()
+	if len(cache.waiters) != 1 {
+		t.Fatalf("waiters after one of two streams left = %d, want 1", len(cache.waiters))
+	}
+	releaseSecond()
+	if len(cache.waiters) != 0 {
+		t.Fatalf("waiters after every stream left = %d, want 0", len(cache.waiters))
+	}
+
+	// A store wakes the waiting stream; releasing afterwards leaves a newer waiter alone
+	refreshed, release := cache.refreshed(51.5, -0.12, opts)
+	if _, err := cache.CurrentAndHourly(context.Background(), 51.5, -0.12, opts); err != nil {
+		t.Fatalf("CurrentAndHourly: %v", err)
+	}
+	select {
+	case <-refreshed:
+	default:
+		t.Fatal("storing the coordinate didn't wake its waiter")
+	}
+	_, releaseNewer := cache.refreshed(51.5, -0.12, opts)
+	release()
+	if len(cache.waiters) != 1 {
+		t.Fatalf("waiters after releasing a woken subscription = %d, want the newer 1", len(cache.waiters))
+	}
+	releaseNewer()
+	if len(cache.waiters) != 0 {
+		t.Fatalf("waiters after every stream left = %d, want 0", len(cache.waiters))
+	}
+}
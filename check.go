@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/charmbracelet/log"
+)
+
+// checkLocation is where -check asks for the weather; any valid coordinate would do
+var checkLocation = Coordinates{Lat: 51.5, Lon: -0.12}
+
+// runCheck makes one minimal One Call request with each configured API key and logs whether
+// it was accepted, and why not otherwise. It reports whether every key passed.
+func runCheck(ctx context.Context, p *OpenWeatherMapProvider) bool {
+	opts := FetchOptions{Units: unitsMetric, Exclude: currentExclude}
+	reqURL := p.requestURL(checkLocation.Lat, checkLocation.Lon, opts)
+	log.Info("Checking OpenWeatherMap API keys", "keys", p.keys.size(), "url", reqURL+"&appid="+redactedAPIKey)
+
+	passed := true
+	for i, key := range p.keys.keys {
+		if _, _, err := p.fetchOnce(ctx, reqURL+"&appid="+key); err != nil {
+			log.Error("API key check failed", "key_index", i, "reason", checkFailure(err), "error", err)
+			passed = false
+			continue
+		}
+		log.Info("API key check passed", "key_index", i)
+	}
+	return passed
+}
+
+// checkFailure explains a failed check request to the operator
+func checkFailure(err error) string {
+	var rateLimited *RateLimitError
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		return "key rejected (401): it is wrong, not yet active, or lacks a One Call 3.0 subscription"
+	case errors.As(err, &rateLimited):
+		return "key rate limited (429): it is valid but its quota is used up"
+	case errors.Is(err, ErrUpstreamUnavailable):
+		return "OpenWeatherMap unreachable: network error or server error"
+	case errors.Is(err, ErrDecode):
+		return "unexpected response body: check the upstream URL"
+	default:
+		return "request refused: check the upstream URL"
+	}
+}
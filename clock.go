@@ -0,0 +1,16 @@
+package main
+
+import "time"
+
+// Clock supplies the current time so time-dependent logic can be pinned to a fixed instant
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock backed by the wall clock
+type systemClock struct{}
+
+// Now returns the current wall-clock time
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// CompareLocation is one named coordinate in a comparison request
+type CompareLocation struct {
+	Name string  `json:"name"`
+	Lat  float64 `json:"lat"`
+	Lon  float64 `json:"lon"`
+}
+
+// ComparedLocation is one location's place in a comparison. Predicted locations are ranked
+// from 1; locations that could not be predicted carry an Error instead and come last.
+type ComparedLocation struct {
+	Rank       int                `json:"rank,omitempty"`
+	Name       string             `json:"name"`
+	Lat        float64            `json:"lat"`
+	Lon        float64            `json:"lon"`
+	Prediction *RainbowPrediction `json:"prediction,omitempty"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// handleCompare predicts several named locations concurrently and ranks them by likelihood,
+// highest first, to answer where a rainbow is most likely. Ties keep request order.
+func (s *Server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	logger := log.FromContext(r.Context())
+	if !acceptsJSON(w, r) {
+		return
+	}
+	opts, err := s.parsePredictOptions(r)
+	if err != nil {
+		logger.Error("Invalid prediction options", "error", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var locations []CompareLocation
+	if status, err := decodeJSONBody(r, &locations); err != nil {
+		logger.Error("Invalid compare request body", "error", err)
+		writeJSONError(w, status, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if len(locations) > s.config.MaxBatchSize {
+		logger.Error("Comparison too large", "locations", len(locations), "max", s.config.MaxBatchSize)
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf(
+			"comparison of %d locations exceeds the maximum of %d", len(locations), s.config.MaxBatchSize))
+		return
+	}
+	for i, loc := range locations {
+		if strings.TrimSpace(loc.Name) == "" {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Location %d has no name", i))
+			return
+		}
+	}
+
+	predictionRequests.Add(float64(len(locations)))
+	logger.Info("Handling compare request", "locations", len(locations), "options", opts)
+	setLanguageHeaders(w, opts.Language)
+
+	batch := make([]BatchLocation, len(locations))
+	for i, loc := range locations {
+		batch[i] = BatchLocation{Lat: loc.Lat, Lon: loc.Lon}
+	}
+	results := s.predictLocations(r.Context(), batch, opts)
+
+	compared := make([]ComparedLocation, len(locations))
+	for i, loc := range locations {
+		compared[i] = ComparedLocation{
+			Name:       loc.Name,
+			Lat:        loc.Lat,
+			Lon:        loc.Lon,
+			Prediction: results[i].Prediction,
+			Error:      results[i].Error,
+		}
+	}
+	slices.SortStableFunc(compared, compareByLikelihood)
+	for i := range compared {
+		if compared[i].Prediction != nil {
+			compared[i].Rank = i + 1
+		}
+	}
+
+	writeJSON(w, http.StatusOK, compared)
+}
+
+// compareByLikelihood orders predicted locations by descending likelihood, ahead of failed ones
+func compareByLikelihood(a, b ComparedLocation) int {
+	switch {
+	case a.Prediction == nil && b.Prediction == nil:
+		return 0
+	case a.Prediction == nil:
+		return 1
+	case b.Prediction == nil:
+		return -1
+	}
+	return cmp.Compare(b.Prediction.Likelihood, a.Prediction.Likelihood)
+}
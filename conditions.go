@@ -0,0 +1,68 @@
+package main
+
+import "net/http"
+
+// ConditionMapping describes how one range of OpenWeatherMap condition IDs affects the score
+type ConditionMapping struct {
+	MinID     int    `json:"minId"`
+	MaxID     int    `json:"maxId"`
+	Condition string `json:"condition"`
+	// Suitable is false for conditions that can't produce a rainbow, which always score zero
+	Suitable bool `json:"suitable"`
+	// RainShare is the share of the full rain boost the condition earns
+	RainShare float64 `json:"rainShare"`
+	// Multiplier is the boost applied to the weighted factors under the configured weights
+	Multiplier float64 `json:"multiplier"`
+}
+
+// ConditionsResponse documents how weather conditions map to likelihood adjustments
+type ConditionsResponse struct {
+	// Mappings are checked in order and the first range containing the condition ID applies
+	Mappings []ConditionMapping `json:"mappings"`
+	// PopBoost applies instead when a suitable condition earns no rain boost but the
+	// probability of precipitation exceeds PopThreshold
+	PopBoost     float64 `json:"popBoost"`
+	PopThreshold float64 `json:"popThreshold"`
+	// RainVolumeBoost applies in place of either boost once RainVolumeSaturation millimetres
+	// of rain fell over the last hour, scaled down for less; 0 when disabled
+	RainVolumeBoost      float64 `json:"rainVolumeBoost"`
+	RainVolumeSaturation float64 `json:"rainVolumeSaturation"`
+}
+
+// handleConditions lists the condition ID ranges and the likelihood adjustment each maps to,
+// derived from the same rules the model scores with
+func (s *Server) handleConditions(w http.ResponseWriter, r *http.Request) {
+	writeConditionalJSON(w, r, conditionMappings(s.config.Weights))
+}
+
+// conditionMappings builds the condition listing from precipitationRules under weights
+func conditionMappings(weights LikelihoodWeights) ConditionsResponse {
+	mappings := make([]ConditionMapping, 0, len(precipitationRules)+2)
+	mappings = append(mappings, ConditionMapping{
+		MinID: 0, MaxID: minSuitableConditionID - 1, Condition: "unknown", Multiplier: 1,
+	})
+	for _, rule := range precipitationRules {
+		multiplier := 1.0
+		if rule.scale > 0 {
+			multiplier = rainBoost(weights, rule.scale)
+		}
+		mappings = append(mappings, ConditionMapping{
+			MinID:      rule.minID,
+			MaxID:      rule.maxID,
+			Condition:  rule.condition,
+			Suitable:   suitableCondition(rule.minID),
+			RainShare:  rule.scale,
+			Multiplier: multiplier,
+		})
+	}
+	mappings = append(mappings, ConditionMapping{
+		MinID: maxSuitableConditionID + 1, MaxID: 899, Condition: "atmosphere, clear or clouds", Multiplier: 1,
+	})
+	return ConditionsResponse{
+		Mappings:             mappings,
+		PopBoost:             weights.PopBoost,
+		PopThreshold:         weights.PopThreshold,
+		RainVolumeBoost:      weights.RainVolumeBoost,
+		RainVolumeSaturation: rainVolumeSaturation,
+	}
+}
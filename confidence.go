@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// forecastHorizon is how far ahead a forecast hour can be before its confidence bottoms out
+const forecastHorizon = 48 * time.Hour
+
+// staleObservationSpan is how long past the configured maximum data age confidence takes to
+// bottom out
+const staleObservationSpan = 12 * time.Hour
+
+// minTimingConfidence is the share of confidence kept for far-off or stale data
+const minTimingConfidence = 0.5
+
+// predictionConfidence rates from 0 to 1 how far the inputs behind a likelihood can be
+// trusted, independently of the likelihood itself, so "confidently unlikely" can be told
+// apart from "not enough data". It combines how complete the conditions are, how far ahead
+// they are forecast and how old the upstream observation is.
+func predictionConfidence(c Conditions, observed, now time.Time, maxAge time.Duration) float64 {
+	return dataCompleteness(c) * forecastLeadFactor(c.Time.Sub(now)) * observationAgeFactor(observed, now, maxAge)
+}
+
+// dataCompleteness is the share of the scoring inputs that were actually reported. Upstream
+// omits missing values, which decode as zero; a zero UV index only counts as missing while
+// the sun is up.
+func dataCompleteness(c Conditions) float64 {
+	present := []bool{
+		len(c.Weather) > 0,
+		c.Humidity > 0,
+		c.Visibility > 0,
+		c.UVI > 0 || solarAltitude(c.Lat, c.Lon, c.Time) <= 0,
+	}
+	count := 0
+	for _, ok := range present {
+		if ok {
+			count++
+		}
+	}
+	return float64(count) / float64(len(present))
+}
+
+// forecastLeadFactor lowers confidence linearly for hours further ahead, down to
+// minTimingConfidence at forecastHorizon
+func forecastLeadFactor(lead time.Duration) float64 {
+	if lead <= 0 {
+		return 1
+	}
+	return math.Max(1-(1-minTimingConfidence)*lead.Hours()/forecastHorizon.Hours(), minTimingConfidence)
+}
+
+// observationAgeFactor lowers confidence once the upstream observation is older than
+// maxAge. An unknown observation time gets the minimum.
+func observationAgeFactor(observed, now time.Time, maxAge time.Duration) float64 {
+	if observed.IsZero() {
+		return minTimingConfidence
+	}
+	stale := now.Sub(observed) - maxAge
+	if stale <= 0 {
+		return 1
+	}
+	return math.Max(1-(1-minTimingConfidence)*stale.Hours()/staleObservationSpan.Hours(), minTimingConfidence)
+}
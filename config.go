@@ -0,0 +1,416 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Config holds the runtime settings for the rainbow prediction server
+type Config struct {
+	// Addr is the host:port the server listens on
+	Addr string
+	// TLSCertFile and TLSKeyFile enable HTTPS when both are set
+	TLSCertFile string
+	TLSKeyFile  string
+	// HTTPRedirectAddr, when set alongside TLS, is a host:port answering plain HTTP with redirects to HTTPS
+	HTTPRedirectAddr string
+	// SnapshotMode is snapshotRecord to save upstream responses to SnapshotDir, snapshotReplay
+	// to serve them from there instead of the network, or empty for neither
+	SnapshotMode string
+	SnapshotDir  string
+	// StaticDir is the directory containing index.html
+	StaticDir string
+	// LogLevel is the minimum level of log messages that are written
+	LogLevel log.Level
+	// APIKeys are the authentication tokens for the OpenWeatherMap API, used round-robin
+	APIKeys []string
+	// APITokens are the bearer tokens clients must present; empty leaves the API open
+	APITokens []string
+	// UpstreamBaseURL is the One Call endpoint, overridable to use a mock or compatible proxy
+	UpstreamBaseURL string
+	// GeocodeBaseURL is the direct geocoding endpoint, overridable like UpstreamBaseURL
+	GeocodeBaseURL string
+	// UserAgent identifies the server on OpenWeatherMap requests
+	UserAgent string
+	// Check makes one request with each API key, reports the result and exits instead of serving
+	Check bool
+	// CacheTTL is how long fetched weather is reused for the same coordinate
+	CacheTTL time.Duration
+	// CachePrecision is how many decimal places coordinates are rounded to when keying the
+	// weather cache; fewer places share entries over a wider area
+	CachePrecision int
+	// WarmLocations are kept in the weather cache by a background warmer; empty disables it
+	WarmLocations []Coordinates
+	// WarmUnits are the unit systems each warm location is fetched in
+	WarmUnits []string
+	// WarmInterval is how often the warm locations are refetched, before jitter
+	WarmInterval time.Duration
+	// GeocodeCacheTTL is how long a resolved place name is reused
+	GeocodeCacheTTL time.Duration
+	// GeocodeCacheSize is how many resolved place names are kept before the least recently used is dropped
+	GeocodeCacheSize int
+	// MaxDataAge is how old upstream weather may be before predictions from it are flagged stale
+	MaxDataAge time.Duration
+	// HeatmapConcurrency bounds how many grid cells a heatmap scan fetches at once
+	HeatmapConcurrency int
+	// HeatmapCacheDir is where finished heatmaps are cached on disk; empty disables the cache
+	HeatmapCacheDir string
+	// HeatmapCacheMaxBytes bounds the total size of the heatmap disk cache
+	HeatmapCacheMaxBytes int
+	// HeatmapResolution is the grid spacing in degrees used when a heatmap request gives none
+	HeatmapResolution float64
+	// MaxHeatmapCells is the largest grid a single heatmap request may scan
+	MaxHeatmapCells int
+	// HeatmapTimeout bounds how long a heatmap scan may run before the cells scored so far
+	// are returned as a truncated grid; zero leaves scans unbounded
+	HeatmapTimeout time.Duration
+	// BatchConcurrency bounds how many locations a batch prediction or comparison fetches at once
+	BatchConcurrency int
+	// MaxBatchSize is the most locations a batch prediction or comparison may ask for; more are refused with 413
+	MaxBatchSize int
+	// BatchChunkSize is how many batch locations are predicted before their results are written
+	BatchChunkSize int
+	// MaxRequestBodyBytes is the largest request body accepted; bigger ones are refused with 413
+	MaxRequestBodyBytes int
+	// ReadyCacheTTL is how long an upstream readiness check result is reused
+	ReadyCacheTTL time.Duration
+	// ShutdownTimeout is how long in-flight requests may keep running after a stop signal
+	ShutdownTimeout time.Duration
+	// UpstreamMaxAttempts is how many times a failing OpenWeatherMap request is tried in total
+	UpstreamMaxAttempts int
+	// UpstreamConcurrency bounds how many OpenWeatherMap requests are in flight at once, server-wide
+	UpstreamConcurrency int
+	// UpstreamRetryBackoff is the base delay before the first retry; it doubles on each attempt
+	UpstreamRetryBackoff time.Duration
+	// BreakerThreshold is how many consecutive upstream failures open the circuit breaker
+	BreakerThreshold int
+	// BreakerCooldown is how long the open circuit breaker fails fast before probing the
+	// upstream again; zero disables the breaker
+	BreakerCooldown time.Duration
+	// LikelihoodModel names the model that scores rainbow likelihood
+	LikelihoodModel string
+	// Weights tunes the heuristic likelihood model
+	Weights LikelihoodWeights
+	// LikelihoodThreshold is the default likelihood below which a prediction reports no rainbow expected
+	LikelihoodThreshold float64
+	// RateLimit is the sustained requests per second allowed for each client IP; zero disables limiting
+	RateLimit float64
+	// RateLimitBurst is how many requests a client may make at once before being limited
+	RateLimitBurst int
+	// StreamInterval is how often a WebSocket subscriber is sent a fresh prediction
+	StreamInterval time.Duration
+	// MaxStreamSubscribers bounds how many WebSocket prediction streams may be open at once
+	MaxStreamSubscribers int
+	// CORSAllowedOrigins lists origins allowed to call the API from a browser; empty means same-origin only
+	CORSAllowedOrigins []string
+	// CORSAllowedMethods lists the methods allowed in cross-origin requests
+	CORSAllowedMethods []string
+	// CORSAllowedHeaders lists the request headers allowed in cross-origin requests
+	CORSAllowedHeaders []string
+}
+
+// loadConfig reads the server configuration from command-line flags and the environment.
+// Flags take precedence over their matching environment variables.
+func loadConfig() (Config, error) {
+	cfg := Config{
+		APIKeys:   envList("OPENWEATHERMAP_API_KEYS", envList("OPENWEATHERMAP_API_KEY", nil)),
+		APITokens: envList("API_TOKENS", nil),
+	}
+	flag.StringVar(&cfg.Addr, "addr", envString("ADDR", ":8080"), "host:port to listen on (env ADDR)")
+	flag.StringVar(&cfg.TLSCertFile, "tls-cert", envString("TLS_CERT_FILE", ""), "TLS certificate file; serves HTTPS together with -tls-key (env TLS_CERT_FILE)")
+	flag.StringVar(&cfg.TLSKeyFile, "tls-key", envString("TLS_KEY_FILE", ""), "TLS private key file (env TLS_KEY_FILE)")
+	flag.StringVar(&cfg.HTTPRedirectAddr, "http-redirect-addr", envString("HTTP_REDIRECT_ADDR", ""), "host:port redirecting plain HTTP to HTTPS; requires TLS (env HTTP_REDIRECT_ADDR)")
+	flag.StringVar(&cfg.SnapshotMode, "snapshot-mode", envString("SNAPSHOT_MODE", snapshotOff), "record or replay upstream responses; empty calls the API normally (env SNAPSHOT_MODE)")
+	flag.StringVar(&cfg.SnapshotDir, "snapshot-dir", envString("SNAPSHOT_DIR", "snapshots"), "directory for recorded upstream responses (env SNAPSHOT_DIR)")
+	flag.StringVar(&cfg.StaticDir, "static-dir", envString("STATIC_DIR", "."), "directory containing index.html (env STATIC_DIR)")
+	logLevel := flag.String("log-level", envString("LOG_LEVEL", "info"), "minimum log level: debug, info, warn, error or fatal (env LOG_LEVEL)")
+	flag.StringVar(&cfg.HeatmapCacheDir, "heatmap-cache-dir", envString("HEATMAP_CACHE_DIR", ""), "directory for caching heatmaps on disk; empty disables it (env HEATMAP_CACHE_DIR)")
+	flag.StringVar(&cfg.UpstreamBaseURL, "upstream-url", envString("OPENWEATHERMAP_BASE_URL", defaultBaseURL), "OpenWeatherMap One Call endpoint (env OPENWEATHERMAP_BASE_URL)")
+	flag.StringVar(&cfg.GeocodeBaseURL, "geocode-url", envString("OPENWEATHERMAP_GEOCODE_URL", defaultGeocodeURL), "OpenWeatherMap geocoding endpoint (env OPENWEATHERMAP_GEOCODE_URL)")
+	flag.StringVar(&cfg.UserAgent, "user-agent", envString("USER_AGENT", defaultUserAgent()), "User-Agent sent on OpenWeatherMap requests (env USER_AGENT)")
+	flag.BoolVar(&cfg.Check, "check", false, "check each API key with one minimal OpenWeatherMap request, report the result and exit")
+	flag.Parse()
+
+	var err error
+	if cfg.LogLevel, err = log.ParseLevel(*logLevel); err != nil {
+		return Config{}, fmt.Errorf("invalid log level %q: must be debug, info, warn, error or fatal", *logLevel)
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return Config{}, errors.New("-tls-cert and -tls-key must be given together")
+	}
+	if cfg.HTTPRedirectAddr != "" && cfg.TLSCertFile == "" {
+		return Config{}, errors.New("-http-redirect-addr requires -tls-cert and -tls-key")
+	}
+	switch cfg.SnapshotMode {
+	case snapshotOff, snapshotRecord:
+	case snapshotReplay:
+		// Replays never reach the API, so no key is needed to develop against them
+		if len(cfg.APIKeys) == 0 {
+			cfg.APIKeys = []string{replayAPIKey}
+		}
+	default:
+		return Config{}, fmt.Errorf("invalid snapshot mode %q: must be %q, %q or empty", cfg.SnapshotMode, snapshotRecord, snapshotReplay)
+	}
+	if len(cfg.APIKeys) == 0 {
+		return Config{}, errors.New("neither OPENWEATHERMAP_API_KEYS nor OPENWEATHERMAP_API_KEY environment variable is set")
+	}
+	if err := validateBaseURL("upstream URL", cfg.UpstreamBaseURL); err != nil {
+		return Config{}, err
+	}
+	if err := validateBaseURL("geocode URL", cfg.GeocodeBaseURL); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.CacheTTL, err = envDuration("WEATHER_CACHE_TTL", 10*time.Minute); err != nil {
+		return Config{}, err
+	}
+	if cfg.CachePrecision, err = envInt("WEATHER_CACHE_PRECISION", 2); err != nil {
+		return Config{}, err
+	}
+	if cfg.CachePrecision < 0 || cfg.CachePrecision > maxCachePrecision {
+		return Config{}, fmt.Errorf("invalid WEATHER_CACHE_PRECISION: must be between 0 and %d", maxCachePrecision)
+	}
+	if cfg.WarmLocations, err = parseWarmLocations(os.Getenv("WARM_LOCATIONS")); err != nil {
+		return Config{}, fmt.Errorf("invalid WARM_LOCATIONS: %w", err)
+	}
+	cfg.WarmUnits = envList("WARM_UNITS", []string{unitsMetric})
+	for _, units := range cfg.WarmUnits {
+		if units != unitsMetric && units != unitsImperial {
+			return Config{}, fmt.Errorf("invalid WARM_UNITS: %q must be %q or %q", units, unitsMetric, unitsImperial)
+		}
+	}
+	// Refetching at three quarters of the TTL keeps entries from expiring even with jitter
+	if cfg.WarmInterval, err = envDuration("WARM_INTERVAL", cfg.CacheTTL*3/4); err != nil {
+		return Config{}, err
+	}
+	if len(cfg.WarmLocations) > 0 && cfg.WarmInterval == 0 {
+		return Config{}, errors.New("invalid WARM_INTERVAL: must be greater than zero when WARM_LOCATIONS is set")
+	}
+	if cfg.MaxDataAge, err = envDuration("MAX_DATA_AGE", 30*time.Minute); err != nil {
+		return Config{}, err
+	}
+	if cfg.GeocodeCacheTTL, err = envDuration("GEOCODE_CACHE_TTL", 7*24*time.Hour); err != nil {
+		return Config{}, err
+	}
+	if cfg.GeocodeCacheSize, err = envPositiveInt("GEOCODE_CACHE_SIZE", 1000); err != nil {
+		return Config{}, err
+	}
+	if cfg.HeatmapConcurrency, err = envPositiveInt("HEATMAP_CONCURRENCY", 8); err != nil {
+		return Config{}, err
+	}
+	if cfg.HeatmapCacheMaxBytes, err = envPositiveInt("HEATMAP_CACHE_MAX_BYTES", 64<<20); err != nil {
+		return Config{}, err
+	}
+	if cfg.HeatmapResolution, err = envFloat("HEATMAP_DEFAULT_RESOLUTION", 0.05); err != nil {
+		return Config{}, err
+	}
+	if !(cfg.HeatmapResolution > 0) {
+		return Config{}, errors.New("invalid HEATMAP_DEFAULT_RESOLUTION: must be greater than 0")
+	}
+	if cfg.MaxHeatmapCells, err = envPositiveInt("MAX_HEATMAP_CELLS", 500); err != nil {
+		return Config{}, err
+	}
+	if cfg.HeatmapTimeout, err = envDuration("HEATMAP_TIMEOUT", 60*time.Second); err != nil {
+		return Config{}, err
+	}
+	if cfg.BatchConcurrency, err = envPositiveInt("BATCH_CONCURRENCY", 8); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxBatchSize, err = envPositiveInt("MAX_BATCH_SIZE", 100); err != nil {
+		return Config{}, err
+	}
+	if cfg.BatchChunkSize, err = envPositiveInt("BATCH_CHUNK_SIZE", 20); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxRequestBodyBytes, err = envPositiveInt("MAX_REQUEST_BODY_BYTES", 1<<20); err != nil {
+		return Config{}, err
+	}
+	if cfg.ReadyCacheTTL, err = envDuration("READY_CACHE_TTL", time.Minute); err != nil {
+		return Config{}, err
+	}
+	if cfg.ShutdownTimeout, err = envDuration("SHUTDOWN_TIMEOUT", 15*time.Second); err != nil {
+		return Config{}, err
+	}
+	if cfg.UpstreamMaxAttempts, err = envPositiveInt("UPSTREAM_MAX_ATTEMPTS", 3); err != nil {
+		return Config{}, err
+	}
+	if cfg.UpstreamConcurrency, err = envPositiveInt("UPSTREAM_CONCURRENCY", 16); err != nil {
+		return Config{}, err
+	}
+	if cfg.UpstreamRetryBackoff, err = envDuration("UPSTREAM_RETRY_BACKOFF", 250*time.Millisecond); err != nil {
+		return Config{}, err
+	}
+	if cfg.BreakerThreshold, err = envPositiveInt("UPSTREAM_BREAKER_THRESHOLD", 5); err != nil {
+		return Config{}, err
+	}
+	if cfg.BreakerCooldown, err = envDuration("UPSTREAM_BREAKER_COOLDOWN", 30*time.Second); err != nil {
+		return Config{}, err
+	}
+	cfg.LikelihoodModel = envString("LIKELIHOOD_MODEL", defaultLikelihoodModel)
+	if cfg.Weights, err = loadLikelihoodWeights(); err != nil {
+		return Config{}, err
+	}
+	if cfg.LikelihoodThreshold, err = envFloat("LIKELIHOOD_THRESHOLD", 0.1); err != nil {
+		return Config{}, err
+	}
+	if !(cfg.LikelihoodThreshold >= 0 && cfg.LikelihoodThreshold <= 1) {
+		return Config{}, errors.New("invalid LIKELIHOOD_THRESHOLD: must be between 0 and 1")
+	}
+	if cfg.RateLimit, err = envFloat("RATE_LIMIT_RPS", 5); err != nil {
+		return Config{}, err
+	}
+	if cfg.RateLimit < 0 {
+		return Config{}, errors.New("invalid RATE_LIMIT_RPS: must not be negative")
+	}
+	if cfg.RateLimitBurst, err = envPositiveInt("RATE_LIMIT_BURST", 10); err != nil {
+		return Config{}, err
+	}
+	if cfg.StreamInterval, err = envDuration("WS_UPDATE_INTERVAL", 5*time.Minute); err != nil {
+		return Config{}, err
+	}
+	if cfg.StreamInterval == 0 {
+		return Config{}, errors.New("invalid WS_UPDATE_INTERVAL: must be greater than zero")
+	}
+	if cfg.MaxStreamSubscribers, err = envPositiveInt("MAX_WS_SUBSCRIBERS", 100); err != nil {
+		return Config{}, err
+	}
+	cfg.CORSAllowedOrigins = envList("CORS_ALLOWED_ORIGINS", nil)
+	cfg.CORSAllowedMethods = envList("CORS_ALLOWED_METHODS", []string{"GET", "POST", "OPTIONS"})
+	cfg.CORSAllowedHeaders = envList("CORS_ALLOWED_HEADERS", []string{"Content-Type", "Authorization"})
+	return cfg, nil
+}
+
+// tlsEnabled reports whether the server should serve HTTPS
+func (c Config) tlsEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// validateBaseURL checks that an endpoint is an absolute http(s) URL without a query, since
+// request parameters are appended to it
+func validateBaseURL(name, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" {
+		return fmt.Errorf("invalid %s %q: must be an absolute http or https URL without a query", name, raw)
+	}
+	return nil
+}
+
+// envString reads a string from the environment, falling back to def when unset
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envDuration reads a duration such as "10m" from the environment, falling back to def when unset
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid %s: must not be negative", key)
+	}
+	return d, nil
+}
+
+// envInt reads an integer from the environment, falling back to def when unset
+func envInt(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return n, nil
+}
+
+// envPositiveInt reads a positive integer from the environment, falling back to def when unset
+func envPositiveInt(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid %s: must be greater than zero", key)
+	}
+	return n, nil
+}
+
+// loadLikelihoodWeights reads likelihood model weights from the environment, keeping
+// the defaults for any that are unset
+func loadLikelihoodWeights() (LikelihoodWeights, error) {
+	w := defaultLikelihoodWeights
+	fields := []struct {
+		key   string
+		value *float64
+	}{
+		{"WEIGHT_CLOUD", &w.Cloud},
+		{"WEIGHT_HUMIDITY", &w.Humidity},
+		{"WEIGHT_UVI", &w.UVI},
+		{"WEIGHT_VISIBILITY", &w.Visibility},
+		{"WEIGHT_WIND", &w.Wind},
+		{"WEIGHT_RAIN_BOOST", &w.RainBoost},
+		{"WEIGHT_POP_BOOST", &w.PopBoost},
+		{"WEIGHT_POP_THRESHOLD", &w.PopThreshold},
+		{"WEIGHT_RAIN_VOLUME_BOOST", &w.RainVolumeBoost},
+	}
+	for _, f := range fields {
+		v, err := envFloat(f.key, *f.value)
+		if err != nil {
+			return LikelihoodWeights{}, err
+		}
+		*f.value = v
+	}
+	if err := w.validate(); err != nil {
+		return LikelihoodWeights{}, err
+	}
+	return w, nil
+}
+
+// envFloat reads a floating point number from the environment, falling back to def when unset
+func envFloat(key string, def float64) (float64, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return f, nil
+}
+
+// envList reads a comma-separated list from the environment, falling back to def when unset
+func envList(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Coordinates is a latitude and longitude in degrees that has been range checked
+type Coordinates struct {
+	Lat float64
+	Lon float64
+}
+
+// ParseCoordinates parses and range checks a latitude and longitude. Surrounding
+// whitespace, a leading "+" that arrived encoded, leftover percent-encoding and Unicode
+// minus signs are tolerated, so clients that escape signs differently all parse the same.
+func ParseCoordinates(latStr, lonStr string) (Coordinates, error) {
+	lat, err := parseCoordinate(latStr)
+	if err != nil {
+		return Coordinates{}, errors.New("Invalid latitude")
+	}
+	lon, err := parseCoordinate(lonStr)
+	if err != nil {
+		return Coordinates{}, errors.New("Invalid longitude")
+	}
+	c := Coordinates{Lat: lat, Lon: lon}
+	if err := c.validate(); err != nil {
+		return Coordinates{}, err
+	}
+	return c, nil
+}
+
+// parseCoordinate parses a single latitude or longitude leniently
+func parseCoordinate(v string) (float64, error) {
+	if strings.Contains(v, "%") {
+		if unescaped, err := url.QueryUnescape(v); err == nil {
+			v = unescaped
+		}
+	}
+	v = strings.ReplaceAll(strings.TrimSpace(v), "\u2212", "-")
+	return strconv.ParseFloat(v, 64)
+}
+
+// validate checks that Lat is within [-90, 90] and Lon within [-180, 180]
+func (c Coordinates) validate() error {
+	if !(c.Lat >= -90 && c.Lat <= 90) {
+		return fmt.Errorf("latitude %v is out of range; must be between -90 and 90", c.Lat)
+	}
+	if !(c.Lon >= -180 && c.Lon <= 180) {
+		return fmt.Errorf("longitude %v is out of range; must be between -180 and 180", c.Lon)
+	}
+	return nil
+}
+
+// coordinatesParam reads the lat and lon route variables, or the query parameters of the
+// same names for routes without them, as Coordinates
+func coordinatesParam(r *http.Request) (Coordinates, error) {
+	return ParseCoordinates(rawCoordinateParam(r, "lat"), rawCoordinateParam(r, "lon"))
+}
+
+// rawCoordinateParam returns the route variable name, falling back to the query parameter
+func rawCoordinateParam(r *http.Request, name string) string {
+	if v, ok := mux.Vars(r)[name]; ok {
+		return v
+	}
+	return r.URL.Query().Get(name)
+}
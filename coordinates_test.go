@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestParseCoordinates(t *testing.T) {
+	tests := []struct {
+		lat, lon string
+		want     Coordinates
+		wantErr  bool
+	}{
+		{lat: "0", lon: "0", want: Coordinates{0, 0}},
+		{lat: "90", lon: "180", want: Coordinates{90, 180}},
+		{lat: "-90", lon: "-180", want: Coordinates{-90, -180}},
+		{lat: "90.0", lon: "-180.000", want: Coordinates{90, -180}},
+		{lat: "89.9999999", lon: "179.9999999", want: Coordinates{89.9999999, 179.9999999}},
+		{lat: "90.0000001", lon: "0", wantErr: true},
+		{lat: "-90.0000001", lon: "0", wantErr: true},
+		{lat: "0", lon: "180.0000001", wantErr: true},
+		{lat: "0", lon: "-180.0000001", wantErr: true},
+		{lat: "NaN", lon: "0", wantErr: true},
+		{lat: "0", lon: "nan", wantErr: true},
+		{lat: "Inf", lon: "0", wantErr: true},
+		{lat: "0", lon: "-Inf", wantErr: true},
+		{lat: "+Inf", lon: "+Inf", wantErr: true},
+		{lat: "1e309", lon: "0", wantErr: true},
+		{lat: "", lon: "0", wantErr: true},
+		{lat: "north", lon: "0", wantErr: true},
+		// Lenient forms clients send for the same values
+		{lat: " 51.5 ", lon: "%2B0.12", want: Coordinates{51.5, 0.12}},
+		{lat: "−90", lon: "−180", want: Coordinates{-90, -180}},
+		{lat: "%2D33.9", lon: "151.2", want: Coordinates{-33.9, 151.2}},
+	}
+	for _, tt := range tests {
+		got, err := ParseCoordinates(tt.lat, tt.lon)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseCoordinates(%q, %q) = %v, want an error", tt.lat, tt.lon, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCoordinates(%q, %q): unexpected error: %v", tt.lat, tt.lon, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseCoordinates(%q, %q) = %v, want %v", tt.lat, tt.lon, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// corsExposedHeaders are the response headers cross-origin scripts may read beyond the
+// CORS-safelisted set
+var corsExposedHeaders = strings.Join([]string{"ETag", "X-Units", heatmapResolutionHeader, heatmapTruncatedHeader, requestIDHeader}, ", ")
+
+// corsPolicy decides which cross-origin browser requests are allowed
+type corsPolicy struct {
+	origins []string
+	methods string
+	headers string
+}
+
+// newCORSPolicy creates a policy for the given origins; an empty list allows same-origin requests only
+func newCORSPolicy(origins, methods, headers []string) *corsPolicy {
+	return &corsPolicy{
+		origins: origins,
+		methods: strings.Join(methods, ", "),
+		headers: strings.Join(headers, ", "),
+	}
+}
+
+// allowed reports whether origin may make cross-origin requests
+func (c *corsPolicy) allowed(origin string) bool {
+	return slices.Contains(c.origins, "*") || slices.Contains(c.origins, origin)
+}
+
+// middleware adds CORS headers for allowed origins and answers preflight requests
+func (c *corsPolicy) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !c.allowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", c.methods)
+			w.Header().Set("Access-Control-Allow-Headers", c.headers)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/charmbracelet/log"
+)
+
+// maxCachePrecision is the most decimal places a weather cache key may keep; upstream requests
+// carry no more than six, so finer keys could never share less
+const maxCachePrecision = 6
+
+// metersPerDegree is the length of a degree of latitude, near enough for sizing cache cells
+const metersPerDegree = 111_320
+
+// CacheDebugResponse shows how the weather cache groups coordinates, so the effect of the
+// configured precision can be seen
+type CacheDebugResponse struct {
+	// Precision is how many decimal places coordinates are rounded to in cache keys
+	Precision int `json:"precision"`
+	// CellMeters is the north-south size of the area sharing an entry; east-west it shrinks
+	// with the cosine of the latitude
+	CellMeters float64 `json:"cellMeters"`
+	TTLSeconds float64 `json:"ttlSeconds"`
+	// Entries counts the cached responses, including expired ones not yet pruned
+	Entries int `json:"entries"`
+	// Key is the entry an hourly prediction at the lat and lon query parameters would use
+	Key string `json:"key,omitempty"`
+}
+
+// handleCacheDebug describes the weather cache, and with lat and lon, the key a prediction
+// there would use
+func (s *Server) handleCacheDebug(w http.ResponseWriter, r *http.Request) {
+	cache, ok := s.provider.(*CachingProvider)
+	if !ok {
+		writeJSONError(w, http.StatusNotImplemented, "The weather cache is not enabled")
+		return
+	}
+	units, err := parseUnits(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response := CacheDebugResponse{
+		Precision:  cache.precision,
+		CellMeters: metersPerDegree / math.Pow(10, float64(cache.precision)),
+		TTLSeconds: cache.ttl.Seconds(),
+		Entries:    cache.size(),
+	}
+	query := r.URL.Query()
+	if query.Has("lat") || query.Has("lon") {
+		coords, err := coordinatesParam(r)
+		if err != nil {
+			log.FromContext(r.Context()).Error("Invalid coordinates", "error", err)
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		response.Key = cache.key(coords.Lat, coords.Lon, FetchOptions{Units: units, Exclude: hourlyExclude})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// decodePartialWeatherData salvages a One Call body that failed to decode as a whole, such as
+// one cut off mid-transfer. The top-level blocks are read in order until one fails, keeping the
+// hourly and daily entries decoded before the break. The result is usable only when the current
+// block decoded intact; without it there is nothing safe to predict from.
+func decodePartialWeatherData(body []byte) (WeatherData, bool) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return WeatherData{}, false
+	}
+
+	var data WeatherData
+	current, complete := false, false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		key, ok := tok.(string)
+		if !ok {
+			// The closing brace: every block was read, so only trailing data was at fault
+			complete = true
+			break
+		}
+		switch key {
+		case "timezone":
+			err = dec.Decode(&data.Timezone)
+		case "timezone_offset":
+			err = dec.Decode(&data.TimezoneOffset)
+		case "current":
+			err = dec.Decode(&data.Current)
+			current = err == nil
+		case "hourly":
+			data.Hourly, err = decodeArrayPrefix[HourlyWeather](dec)
+		case "daily":
+			data.Daily, err = decodeArrayPrefix[DailyWeather](dec)
+		default:
+			err = dec.Decode(new(json.RawMessage))
+		}
+		if err != nil {
+			break
+		}
+	}
+	data.Partial = !complete
+	return data, current
+}
+
+// decodeArrayPrefix decodes the elements of a JSON array up to the first one that fails,
+// returning those decoded along with the error. Hours are consecutive, so the entries after a
+// bad one are dropped rather than leaving a gap.
+func decodeArrayPrefix[T any](dec *json.Decoder) ([]T, error) {
+	tok, err := dec.Token()
+	if err != nil || tok == nil {
+		return nil, err
+	}
+	if tok != json.Delim('[') {
+		return nil, fmt.Errorf("expected an array, got %v", tok)
+	}
+
+	var items []T
+	for dec.More() {
+		var item T
+		if err := dec.Decode(&item); err != nil {
+			return items, err
+		}
+		items = append(items, item)
+	}
+	_, err = dec.Token()
+	return items, err
+}
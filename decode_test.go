@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDecodePartialWeatherData(t *testing.T) {
+	body := testAfternoonBody
+	// hourOffset is where the nth hourly entry starts in body
+	hourOffset := func(n int) int {
+		t.Helper()
+		i := strings.Index(body, `"hourly":[`)
+		for range n + 1 {
+			next := strings.Index(body[i+1:], `{"dt":`)
+			if next < 0 {
+				t.Fatalf("testAfternoonBody has fewer than %d hours", n+1)
+			}
+			i += next + 1
+		}
+		return i
+	}
+
+	tests := []struct {
+		name        string
+		body        string
+		wantUsable  bool
+		wantPartial bool
+		wantHours   int
+	}{
+		{name: "complete", body: body, wantUsable: true, wantHours: 4},
+		{name: "trailing garbage", body: body + "\x00\x00", wantUsable: true, wantHours: 4},
+		{name: "cut in the third hour", body: body[:hourOffset(2)+20], wantUsable: true, wantPartial: true, wantHours: 2},
+		{name: "cut between hours", body: body[:hourOffset(3)], wantUsable: true, wantPartial: true, wantHours: 3},
+		{name: "cut before the hourly block", body: body[:strings.Index(body, `"hourly"`)], wantUsable: true, wantPartial: true},
+		{name: "cut in the current block", body: body[:strings.Index(body, `"humidity"`)], wantPartial: true},
+		{name: "cut in the time zone", body: body[:10], wantPartial: true},
+		{name: "empty", body: ""},
+		{name: "not an object", body: `[1,2,3]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, usable := decodePartialWeatherData([]byte(tt.body))
+			if usable != tt.wantUsable {
+				t.Fatalf("usable = %v, want %v", usable, tt.wantUsable)
+			}
+			if !usable {
+				return
+			}
+			if data.Partial != tt.wantPartial {
+				t.Errorf("partial = %v, want %v", data.Partial, tt.wantPartial)
+			}
+			if len(data.Hourly) != tt.wantHours {
+				t.Errorf("decoded %d hours, want %d", len(data.Hourly), tt.wantHours)
+			}
+			if data.Current.Dt != 1717257600 || data.Timezone != "Europe/London" {
+				t.Errorf("current dt %d in %q, want 1717257600 in Europe/London", data.Current.Dt, data.Timezone)
+			}
+			for i, hour := range data.Hourly {
+				if hour.Dt != 1717257600+int64(i)*3600 {
+					t.Errorf("hour %d has dt %d, want consecutive hours", i, hour.Dt)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodePartialWeatherDataEveryCut(t *testing.T) {
+	body := testAfternoonBody
+	// The body is usable from the cut that keeps the current block's closing brace
+	currentEnd := strings.Index(body, "},\n\"hourly\"") + 1
+	lastHours := 0
+	for n := range len(body) {
+		data, usable := decodePartialWeatherData([]byte(body[:n]))
+		if usable != (n >= currentEnd) {
+			t.Fatalf("cut at %d: usable = %v, want %v", n, usable, n >= currentEnd)
+		}
+		if usable && !data.Partial {
+			t.Fatalf("cut at %d: not marked partial", n)
+		}
+		if len(data.Hourly) < lastHours {
+			t.Fatalf("cut at %d: %d hours, fewer than the %d of a shorter cut", n, len(data.Hourly), lastHours)
+		}
+		lastHours = len(data.Hourly)
+	}
+}
+
+func TestProviderSalvagesTruncatedBody(t *testing.T) {
+	body := testAfternoonBody[:strings.LastIndex(testAfternoonBody, `{"dt":`)+12]
+	p, _ := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	})
+	data, err := p.CurrentAndHourly(context.Background(), 51.5, -0.12, FetchOptions{Units: unitsMetric, Exclude: hourlyExclude})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !data.Partial || len(data.Hourly) != 3 {
+		t.Errorf("got partial %v with %d hours, want partial with the 3 complete hours", data.Partial, len(data.Hourly))
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import "net/http"
+
+// upstreamDescriber is implemented by providers that can show the upstream request they
+// would make for a coordinate without making it
+type upstreamDescriber interface {
+	upstreamURL(lat, lon float64, opts FetchOptions) (string, bool)
+}
+
+// DryRunResponse describes the upstream request a prediction would make, returned instead
+// of the prediction when dryrun=true so inputs can be checked without spending API quota
+type DryRunResponse struct {
+	Lat         float64 `json:"lat"`
+	Lon         float64 `json:"lon"`
+	Units       string  `json:"units"`
+	UpstreamURL string  `json:"upstreamUrl"`
+}
+
+// writeDryRun responds with the parsed coordinates and the redacted upstream URL
+func (s *Server) writeDryRun(w http.ResponseWriter, lat, lon float64, opts FetchOptions) {
+	var upstreamURL string
+	describer, ok := s.provider.(upstreamDescriber)
+	if ok {
+		upstreamURL, ok = describer.upstreamURL(lat, lon, opts)
+	}
+	if !ok {
+		writeJSONError(w, http.StatusNotImplemented, "Dry runs are not supported by the weather provider")
+		return
+	}
+	writeJSON(w, http.StatusOK, DryRunResponse{Lat: lat, Lon: lon, Units: opts.Units, UpstreamURL: upstreamURL})
+}
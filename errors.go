@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultUpstreamRetryAfter is used when OpenWeatherMap rate limits us without saying for how long
+const defaultUpstreamRetryAfter = time.Minute
+
+// Errors reported by the OpenWeatherMap client; callers match them with errors.Is
+var (
+	// ErrUnauthorized is returned when OpenWeatherMap rejects the API key
+	ErrUnauthorized = errors.New("upstream rejected the API key")
+	// ErrRateLimited is returned when OpenWeatherMap rejects a request for exceeding the quota
+	ErrRateLimited = errors.New("upstream rate limit exceeded")
+	// ErrUpstreamUnavailable is returned when OpenWeatherMap can't be reached or answers with a 5xx
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+	// ErrDecode is returned when an OpenWeatherMap response body can't be parsed
+	ErrDecode = errors.New("malformed upstream response")
+	// ErrCircuitOpen is returned without calling OpenWeatherMap while it is failing repeatedly
+	ErrCircuitOpen = errors.New("upstream circuit breaker open")
+)
+
+// RateLimitError reports an upstream 429 along with when requests may resume.
+// It matches ErrRateLimited with errors.Is.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+// Error describes the rate limit and its retry time
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%v; retry after %s", ErrRateLimited, e.RetryAfter)
+}
+
+// Is lets errors.Is(err, ErrRateLimited) match a RateLimitError
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// CircuitOpenError reports a call refused by the circuit breaker along with when calls may
+// resume. It matches ErrCircuitOpen with errors.Is.
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+// Error describes the open circuit and its retry time
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%v; retry after %s", ErrCircuitOpen, e.RetryAfter)
+}
+
+// Is lets errors.Is(err, ErrCircuitOpen) match a CircuitOpenError
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// rateLimitErrorFrom builds a RateLimitError from a 429 response, reading the retry time
+// from Retry-After or X-RateLimit-Reset when the upstream provides them
+func rateLimitErrorFrom(resp *http.Response, now time.Time) *RateLimitError {
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+			return &RateLimitError{RetryAfter: time.Duration(secs) * time.Second}
+		}
+		if at, err := http.ParseTime(v); err == nil && at.After(now) {
+			return &RateLimitError{RetryAfter: at.Sub(now)}
+		}
+	}
+	if v := resp.Header.Get("X-RateLimit-Reset"); v != "" {
+		if reset, err := strconv.ParseInt(v, 10, 64); err == nil {
+			if at := time.Unix(reset, 0); at.After(now) {
+				return &RateLimitError{RetryAfter: at.Sub(now)}
+			}
+		}
+	}
+	return &RateLimitError{RetryAfter: defaultUpstreamRetryAfter}
+}
+
+// upstreamName identifies the weather API in error responses
+const upstreamName = "OpenWeatherMap"
+
+// writeUpstreamError maps an upstream failure to the response clients should see, naming
+// the upstream so monitoring can tell its outages from ours. Rate limits become 429 and an
+// open circuit breaker 503, both with a Retry-After header.
+func writeUpstreamError(w http.ResponseWriter, prefix string, err error) {
+	var rateLimited *RateLimitError
+	var circuitOpen *CircuitOpenError
+	switch {
+	case errors.As(err, &rateLimited):
+		setRetryAfter(w, rateLimited.RetryAfter)
+	case errors.As(err, &circuitOpen):
+		setRetryAfter(w, circuitOpen.RetryAfter)
+	}
+	status := upstreamErrorStatus(err)
+	writeJSON(w, status, ErrorResponse{Error: fmt.Sprintf("%s: %v", prefix, err), Status: status, Upstream: upstreamName})
+}
+
+// setRetryAfter tells the client how many whole seconds to wait before trying again
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+}
+
+// upstreamErrorStatus picks the HTTP status reported to clients for an upstream failure:
+// 429 when we are rate limited, 503 while the circuit breaker is open, 504 when the
+// upstream timed out and 502 otherwise. A rejected key is our misconfiguration rather than
+// the client's, so it is a bad gateway too instead of passing the 401 through.
+func upstreamErrorStatus(err error) int {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// forecastDays is how many days the daily forecast covers
+const forecastDays = 7
+
+// dailyRainbowOffset is how far after sunrise and before sunset each day is scored,
+// placing the sun low enough for a rainbow but clear of the horizon
+const dailyRainbowOffset = time.Hour
+
+// DailyForecast is the per-day rainbow outlook for a location
+type DailyForecast struct {
+	Location string            `json:"location"`
+	Units    string            `json:"units"`
+	Days     []DailyPrediction `json:"days"`
+}
+
+// DailyPrediction is the best rainbow likelihood for a single day
+type DailyPrediction struct {
+	Date       string  `json:"date"`
+	Likelihood float64 `json:"likelihood"`
+	Time       string  `json:"time"`
+}
+
+// handleDailyForecast returns the best rainbow likelihood for each of the next seven days
+func (s *Server) handleDailyForecast(w http.ResponseWriter, r *http.Request) {
+	logger := log.FromContext(r.Context())
+	coords, err := coordinatesParam(r)
+	if err != nil {
+		logger.Error("Invalid coordinates", "error", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	lat, lon := coords.Lat, coords.Lon
+	units, err := parseUnits(r)
+	if err != nil {
+		logger.Error("Invalid units", "error", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.Info("Handling daily forecast request", "latitude", lat, "longitude", lon, "units", units)
+
+	weatherData, err := s.provider.CurrentAndHourly(r.Context(), lat, lon, FetchOptions{Units: units, Exclude: dailyExclude})
+	if err != nil {
+		logger.Error("Error fetching weather data", "error", err)
+		writeUpstreamError(w, "Error fetching weather data", err)
+		return
+	}
+
+	forecast := DailyForecast{
+		Location: fmt.Sprintf("%.4f, %.4f", lat, lon),
+		Units:    units,
+		Days:     []DailyPrediction{},
+	}
+	for i, day := range weatherData.Daily {
+		if i == forecastDays {
+			break
+		}
+		forecast.Days = append(forecast.Days, s.scoreDay(r.Context(), lat, lon, units, day))
+	}
+
+	writeConditionalJSON(w, r, forecast)
+}
+
+// scoreDay scores a day's aggregate weather shortly after sunrise and shortly before
+// sunset, when the sun is low enough for a rainbow, and keeps the better of the two
+func (s *Server) scoreDay(ctx context.Context, lat, lon float64, units string, day DailyWeather) DailyPrediction {
+	prediction := DailyPrediction{
+		Date: time.Unix(day.Dt, 0).UTC().Format(time.DateOnly),
+	}
+
+	var candidates []time.Time
+	if day.Sunrise != 0 {
+		candidates = append(candidates, time.Unix(day.Sunrise, 0).Add(dailyRainbowOffset))
+	}
+	if day.Sunset != 0 {
+		candidates = append(candidates, time.Unix(day.Sunset, 0).Add(-dailyRainbowOffset))
+	}
+
+	var bestTime time.Time
+	for _, t := range candidates {
+		likelihood, _ := s.model.Score(ctx, day.conditions(lat, lon, units, t))
+		if likelihood > prediction.Likelihood {
+			prediction.Likelihood = likelihood
+			bestTime = t
+		}
+	}
+	prediction.Time = noRainbowTime
+	if prediction.Likelihood > 0 {
+		prediction.Time = bestTime.Format(time.RFC3339)
+	}
+	return prediction
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// geocodeCacheEntry is a resolved place name held in the geocoding cache
+type geocodeCacheEntry struct {
+	key       string
+	location  GeoLocation
+	expiresAt time.Time
+}
+
+// CachingGeocoder wraps a Geocoder with a size-bounded LRU cache. Place names rarely move,
+// so entries live far longer than cached weather.
+type CachingGeocoder struct {
+	next  Geocoder
+	ttl   time.Duration
+	size  int
+	clock Clock
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order holds the entries from most to least recently used
+	order *list.List
+}
+
+// NewCachingGeocoder creates a cache in front of next holding up to size names for ttl
+func NewCachingGeocoder(next Geocoder, ttl time.Duration, size int, clock Clock) *CachingGeocoder {
+	return &CachingGeocoder{
+		next:    next,
+		ttl:     ttl,
+		size:    size,
+		clock:   clock,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Geocode serves a name from the cache when fresh and resolves it upstream otherwise.
+// Requests marked with withGeocodeCacheBypass always go upstream and refresh the entry.
+func (c *CachingGeocoder) Geocode(ctx context.Context, name string) (GeoLocation, error) {
+	return c.lookup(ctx, geocodeCacheKey(name), func() (GeoLocation, error) {
+		return c.next.Geocode(ctx, name)
+	})
+}
+
+// GeocodeZip serves a postal code from the cache like Geocode. Codes share the cache with
+// names under a "zip:" prefix so the two can't collide.
+func (c *CachingGeocoder) GeocodeZip(ctx context.Context, code, country string) (GeoLocation, error) {
+	return c.lookup(ctx, "zip:"+geocodeCacheKey(code+","+country), func() (GeoLocation, error) {
+		return c.next.GeocodeZip(ctx, code, country)
+	})
+}
+
+// lookup returns the cached location for key, calling resolve and caching its result on a miss
+func (c *CachingGeocoder) lookup(ctx context.Context, key string, resolve func() (GeoLocation, error)) (GeoLocation, error) {
+	logger := log.FromContext(ctx)
+	if !geocodeCacheBypassed(ctx) {
+		if location, ok := c.get(key); ok {
+			logger.Debug("Geocode cache hit", "key", key)
+			return location, nil
+		}
+	}
+
+	logger.Debug("Geocode cache miss", "key", key)
+	location, err := resolve()
+	if err != nil {
+		return GeoLocation{}, err
+	}
+	c.set(key, location)
+	return location, nil
+}
+
+// get returns the cached location for key if it has not expired, marking it recently used
+func (c *CachingGeocoder) get(key string) (GeoLocation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return GeoLocation{}, false
+	}
+	entry := elem.Value.(*geocodeCacheEntry)
+	if c.clock.Now().After(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return GeoLocation{}, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.location, true
+}
+
+// set stores location under key, evicting the least recently used entry when full
+func (c *CachingGeocoder) set(key string, location GeoLocation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := c.clock.Now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*geocodeCacheEntry)
+		entry.location, entry.expiresAt = location, expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&geocodeCacheEntry{key: key, location: location, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*geocodeCacheEntry).key)
+	}
+}
+
+// geocodeCacheKey normalizes a place name so lookups differing only in case or spacing share an entry
+func geocodeCacheKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// withGeocodeCacheBypass marks ctx so geocoding skips cached results
+func withGeocodeCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, geocodeCacheBypassKey, true)
+}
+
+// geocodeCacheBypassed reports whether ctx was marked by withGeocodeCacheBypass
+func geocodeCacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(geocodeCacheBypassKey).(bool)
+	return bypass
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/charmbracelet/log"
+)
+
+// defaultGeocodeURL is the endpoint for the OpenWeatherMap direct geocoding API. The zip
+// geocoding API is found beside it.
+const defaultGeocodeURL = "https://api.openweathermap.org/geo/1.0/direct"
+
+// ErrLocationNotFound is returned when a place name has no geocoding match
+var ErrLocationNotFound = errors.New("location not found")
+
+// GeoLocation is a place resolved by the geocoding API
+type GeoLocation struct {
+	Name    string  `json:"name"`
+	State   string  `json:"state,omitempty"`
+	Country string  `json:"country"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+}
+
+// Geocoder resolves place names and postal codes to coordinates
+type Geocoder interface {
+	Geocode(ctx context.Context, name string) (GeoLocation, error)
+	GeocodeZip(ctx context.Context, code, country string) (GeoLocation, error)
+}
+
+// Geocode resolves a city name to its best-matching location. Ambiguous names
+// resolve to the top match returned by the API.
+func (p *OpenWeatherMapProvider) Geocode(ctx context.Context, name string) (GeoLocation, error) {
+	logger := log.FromContext(ctx)
+	var matches []GeoLocation
+	err := p.geocode(ctx, func(key string) string {
+		return fmt.Sprintf("%s?q=%s&limit=5&appid=%s", p.geocodeURL, url.QueryEscape(name), key)
+	}, &matches)
+	if err != nil {
+		return GeoLocation{}, err
+	}
+	if len(matches) == 0 {
+		return GeoLocation{}, ErrLocationNotFound
+	}
+
+	logger.Debug("Geocoded city", "name", name, "matches", len(matches), "resolved", matches[0])
+	return matches[0], nil
+}
+
+// GeocodeZip resolves a postal code to the location of its area. country is an ISO 3166
+// country code; the API assumes the US when it is empty.
+func (p *OpenWeatherMapProvider) GeocodeZip(ctx context.Context, code, country string) (GeoLocation, error) {
+	logger := log.FromContext(ctx)
+	zip := code
+	if country != "" {
+		zip += "," + country
+	}
+	var location GeoLocation
+	err := p.geocode(ctx, func(key string) string {
+		return fmt.Sprintf("%s?zip=%s&appid=%s", p.zipGeocodeURL, url.QueryEscape(zip), key)
+	}, &location)
+	if err != nil {
+		return GeoLocation{}, err
+	}
+
+	logger.Debug("Geocoded postal code", "zip", zip, "resolved", location)
+	return location, nil
+}
+
+// geocode makes a geocoding request to the URL built for the picked API key and decodes
+// the response into v, applying the breaker, the upstream slot limit and key benching
+func (p *OpenWeatherMapProvider) geocode(ctx context.Context, reqURL func(key string) string, v any) (err error) {
+	logger := log.FromContext(ctx)
+	if err := p.breaker.allow(); err != nil {
+		return err
+	}
+	defer func() { p.breaker.record(ctx, err) }()
+	if err := p.acquireSlot(ctx); err != nil {
+		return err
+	}
+	defer p.releaseSlot()
+
+	index, key := p.keys.pick(p.clock.Now())
+	err = p.geocodeWithKey(ctx, reqURL(key), v)
+	if reason, bench, refused := keyFailure(err); refused {
+		logger.Warn("API key refused; benching it", "key_index", index, "reason", reason, "for", bench)
+		apiKeysBenched.WithLabelValues(reason).Inc()
+		p.keys.bench(index, p.clock.Now(), bench)
+	}
+	return err
+}
+
+// geocodeWithKey makes a single geocoding request and decodes the response into v. The zip
+// API answers 404 for unknown codes, reported as ErrLocationNotFound.
+func (p *OpenWeatherMapProvider) geocodeWithKey(ctx context.Context, reqURL string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: error making request: %w", ErrUpstreamUnavailable, redactAPIKey(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return rateLimitErrorFrom(resp, p.clock.Now())
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("%w: status code %d", ErrUnauthorized, resp.StatusCode)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrLocationNotFound
+	}
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%w: geocoding request failed with status code: %d", ErrUpstreamUnavailable, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("geocoding request failed with status code: %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+	return nil
+}
+
+// zipGeocodeURL derives the zip geocoding endpoint from the direct geocoding endpoint, which
+// sits beside it, so a custom geocode URL redirects both
+func zipGeocodeURL(geocodeURL string) string {
+	u, err := url.Parse(geocodeURL)
+	if err != nil {
+		return geocodeURL
+	}
+	u.Path = path.Join(path.Dir(u.Path), "zip")
+	return u.String()
+}
+
+// Ping checks that the OpenWeatherMap API is reachable and accepts the configured key.
+// It uses the geocoding API since a lookup there is far cheaper than a One Call request.
+func (p *OpenWeatherMapProvider) Ping(ctx context.Context) error {
+	_, err := p.Geocode(ctx, "London")
+	return err
+}
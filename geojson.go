@@ -0,0 +1,51 @@
+package main
+
+// GeoJSONFeatureCollection is a GeoJSON FeatureCollection (RFC 7946). Resolution and
+// Truncated are foreign members giving the grid spacing in degrees and marking a heatmap
+// whose scan stopped early.
+type GeoJSONFeatureCollection struct {
+	Type       string           `json:"type"`
+	Features   []GeoJSONFeature `json:"features"`
+	Resolution float64          `json:"resolution,omitempty"`
+	Truncated  bool             `json:"truncated,omitempty"`
+}
+
+// GeoJSONFeature is a single GeoJSON Feature
+type GeoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   GeoJSONPoint      `json:"geometry"`
+	Properties HeatmapProperties `json:"properties"`
+}
+
+// GeoJSONPoint is a GeoJSON Point geometry; coordinates are ordered longitude, latitude
+type GeoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// HeatmapProperties are the properties attached to each heatmap Feature. Likelihood is
+// null for a cell whose fetch failed, with Error saying why.
+type HeatmapProperties struct {
+	Likelihood *float64 `json:"likelihood"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// heatmapGeoJSON converts heatmap points into a FeatureCollection of Points
+func heatmapGeoJSON(points []HeatmapData) GeoJSONFeatureCollection {
+	features := make([]GeoJSONFeature, 0, len(points))
+	for _, point := range points {
+		properties := HeatmapProperties{Error: point.Error}
+		if point.Error == "" {
+			properties.Likelihood = &point.Likelihood
+		}
+		features = append(features, GeoJSONFeature{
+			Type: "Feature",
+			Geometry: GeoJSONPoint{
+				Type:        "Point",
+				Coordinates: [2]float64{point.Lon, point.Lat},
+			},
+			Properties: properties,
+		})
+	}
+	return GeoJSONFeatureCollection{Type: "FeatureCollection", Features: features}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// update rewrites the golden files with the current output: go test -run Golden -update
+var update = flag.Bool("update", false, "rewrite testdata/*.golden with the current output")
+
+// assertGolden compares got with testdata/name.golden, or writes it there with -update
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file (run with -update to create it): %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s (run with -update if the change is intended)\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestPredictionGolden(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		current bool
+	}{
+		{name: "prediction", target: "/predict?lat=51.5&lon=-0.12"},
+		{name: "prediction_detailed", target: "/predict?lat=51.5&lon=-0.12&timeline=true&explain=true&includeWeather=true&interpolate=true"},
+		{name: "prediction_current", target: "/predict/current?lat=51.5&lon=-0.12", current: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, &countingProvider{data: testAfternoonWeather(t)})
+			handler := s.handlePrediction
+			if tt.current {
+				handler = s.handleCurrentPrediction
+			}
+			rec := serve(handler, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+			assertGolden(t, tt.name, rec.Body.Bytes())
+		})
+	}
+}
+
+func TestHeatmapGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{name: "heatmap", target: "/heatmap?lat=51.5&lon=-0.12&radius=10&resolution=0.1"},
+		{name: "heatmap_geojson", target: "/heatmap?lat=51.5&lon=-0.12&radius=10&resolution=0.1&format=geojson"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, &countingProvider{data: testAfternoonWeather(t)})
+			rec := serve(s.handleHeatmapData, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+			assertGolden(t, tt.name, rec.Body.Bytes())
+		})
+	}
+}
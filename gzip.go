@@ -0,0 +1,173 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+)
+
+// minGzipSize is the smallest body worth compressing; below it gzip's framing outweighs the savings
+const minGzipSize = 1024
+
+// gzipWriters recycles compressors, which are expensive to allocate per response
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// withGzip compresses response bodies for clients that accept gzip. The first minGzipSize
+// bytes are buffered so small responses go out uncompressed. WebSocket upgrades and
+// responses that already carry a Content-Encoding are passed through untouched.
+func withGzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		// A quality of zero means "not acceptable"
+		if name, value, ok := strings.Cut(params, "="); ok && strings.TrimSpace(name) == "q" {
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			return err == nil && q > 0
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the status and the start of the body until it knows
+// whether the response is big enough to compress
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	buf         []byte
+	// decided is set once the response is committed to either gz or passing through
+	decided bool
+	gz      *gzip.Writer
+}
+
+// WriteHeader records the status; it is sent once the encoding is decided
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+	g.status = status
+	g.wroteHeader = true
+	// Responses that can't carry a body have nothing to compress
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		g.passThrough()
+	}
+}
+
+// Write buffers until minGzipSize bytes have been seen, then streams through the compressor
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.decided {
+		if g.gz != nil {
+			return g.gz.Write(p)
+		}
+		return g.ResponseWriter.Write(p)
+	}
+
+	g.buf = append(g.buf, p...)
+	if len(g.buf) >= minGzipSize {
+		if err := g.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// startGzip commits to a compressed response unless the handler already chose an encoding
+func (g *gzipResponseWriter) startGzip() error {
+	if g.Header().Get("Content-Encoding") != "" {
+		return g.passThrough()
+	}
+	g.decided = true
+	g.Header().Del("Content-Length")
+	g.Header().Set("Content-Encoding", "gzip")
+	g.ResponseWriter.WriteHeader(g.status)
+
+	g.gz = gzipWriters.Get().(*gzip.Writer)
+	g.gz.Reset(g.ResponseWriter)
+	_, err := g.gz.Write(g.buf)
+	g.buf = nil
+	return err
+}
+
+// passThrough commits to an uncompressed response and sends anything buffered so far
+func (g *gzipResponseWriter) passThrough() error {
+	g.decided = true
+	g.ResponseWriter.WriteHeader(g.status)
+	if len(g.buf) == 0 {
+		return nil
+	}
+	_, err := g.ResponseWriter.Write(g.buf)
+	g.buf = nil
+	return err
+}
+
+// Flush sends what has been written so far. A response flushed before reaching
+// minGzipSize is streamed uncompressed, which suits incremental formats.
+func (g *gzipResponseWriter) Flush() {
+	if !g.decided {
+		if !g.wroteHeader {
+			g.WriteHeader(http.StatusOK)
+		}
+		if !g.decided {
+			g.passThrough()
+		}
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// close finishes the response, sending small bodies uncompressed
+func (g *gzipResponseWriter) close() {
+	if !g.decided {
+		if !g.wroteHeader {
+			// The handler wrote nothing at all
+			g.WriteHeader(http.StatusOK)
+		}
+		if !g.decided {
+			g.passThrough()
+		}
+		return
+	}
+	if g.gz == nil {
+		return
+	}
+	if err := g.gz.Close(); err != nil {
+		log.Error("Error finishing gzip response", "error", err)
+	}
+	g.gz.Reset(nil)
+	gzipWriters.Put(g.gz)
+	g.gz = nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// readinessTimeout bounds how long a single upstream readiness ping may take
+const readinessTimeout = 5 * time.Second
+
+// HealthStatus is the JSON body returned by the health endpoints
+type HealthStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Pinger checks that an upstream dependency is reachable
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// readinessChecker caches upstream ping results so frequent probes don't spend API quota
+type readinessChecker struct {
+	pinger Pinger
+	ttl    time.Duration
+	clock  Clock
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	lastErr   error
+}
+
+// newReadinessChecker creates a checker that reuses each ping result for ttl
+func newReadinessChecker(pinger Pinger, ttl time.Duration, clock Clock) *readinessChecker {
+	return &readinessChecker{pinger: pinger, ttl: ttl, clock: clock}
+}
+
+// check returns the cached ping result, pinging the upstream again once it is older than the TTL
+func (c *readinessChecker) check(ctx context.Context) error {
+	logger := log.FromContext(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.checkedAt.IsZero() && c.clock.Now().Sub(c.checkedAt) < c.ttl {
+		return c.lastErr
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	c.lastErr = c.pinger.Ping(ctx)
+	c.checkedAt = c.clock.Now()
+	if c.lastErr != nil {
+		logger.Warn("Upstream readiness check failed", "error", c.lastErr)
+	}
+	return c.lastErr
+}
+
+// handleHealthz reports that the process is up
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, HealthStatus{Status: "ok"})
+}
+
+// handleReadyz reports whether the server can reach OpenWeatherMap with its configured key
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	err := s.checkReady(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, HealthStatus{Status: "unavailable", Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, HealthStatus{Status: "ok"})
+}
+
+// checkReady verifies the API key is configured and the upstream is reachable
+func (s *Server) checkReady(ctx context.Context) error {
+	if len(s.config.APIKeys) == 0 {
+		return errors.New("OpenWeatherMap API key is not configured")
+	}
+	return s.readiness.check(ctx)
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/charmbracelet/log"
+)
+
+// HeatmapData represents the structure of the heatmap data
+type HeatmapData struct {
+	Lat        float64 `json:"lat"`
+	Lon        float64 `json:"lon"`
+	Likelihood float64 `json:"likelihood" nullable:"true"`
+	// Error says why a cell has no likelihood; such cells are only sent with includeErrors=true
+	Error string `json:"error,omitempty"`
+}
+
+// notScanned is the error noted for cells a truncated scan never reached
+const notScanned = "not scanned before the request ended"
+
+// MarshalJSON encodes a failed cell's likelihood as null so it can't be mistaken for a zero score
+func (d HeatmapData) MarshalJSON() ([]byte, error) {
+	type cell HeatmapData
+	if d.Error == "" {
+		return json.Marshal(cell(d))
+	}
+	return json.Marshal(struct {
+		Lat        float64  `json:"lat"`
+		Lon        float64  `json:"lon"`
+		Likelihood *float64 `json:"likelihood"`
+		Error      string   `json:"error"`
+	}{Lat: d.Lat, Lon: d.Lon, Error: d.Error})
+}
+
+// Output formats supported by the heatmap endpoint
+const (
+	heatmapFormatJSON    = "json"
+	heatmapFormatGeoJSON = "geojson"
+	heatmapFormatCSV     = "csv"
+	heatmapFormatSSE     = "sse"
+)
+
+// Grid shapes for a heatmap around a center. A circle keeps only the points within the
+// radius; a square keeps the whole grid around it, about 27% more cells.
+const (
+	heatmapShapeCircle = "circle"
+	heatmapShapeSquare = "square"
+)
+
+// heatmapResolutionHeader reports the grid spacing a heatmap was scanned at, in degrees
+const heatmapResolutionHeader = "X-Heatmap-Resolution"
+
+// heatmapTruncatedHeader marks a heatmap whose scan was stopped before every cell was fetched
+const heatmapTruncatedHeader = "X-Heatmap-Truncated"
+
+// heatmapArea is a region a heatmap scans: a circle around a center or a bounding box
+type heatmapArea interface {
+	// cellCount returns how many grid points the scan would visit, without generating them
+	cellCount() int
+	// points returns the grid points to scan
+	points() []Coordinates
+	// cacheRegion identifies the area in the heatmap disk cache
+	cacheRegion() string
+	// center is the middle of the area, used to name downloads
+	center() (lat, lon float64)
+	// gridResolution is the spacing between grid points in degrees
+	gridResolution() float64
+	// withResolution returns the area gridded at a different spacing
+	withResolution(resolution float64) heatmapArea
+	// validateResolution rejects a spacing the area can't be scanned at
+	validateResolution() error
+}
+
+// circleArea is the grid points within radius miles of a center, or with square set, the
+// whole grid spanning radius miles either side of it
+type circleArea struct {
+	lat, lon, radius, resolution float64
+	square                       bool
+}
+
+// handleHeatmapData processes the heatmap data request. The area is either a circle given
+// by lat, lon and radius, or a bbox of minLon,minLat,maxLon,maxLat.
+func (s *Server) handleHeatmapData(w http.ResponseWriter, r *http.Request) {
+	logger := log.FromContext(r.Context())
+	units, err := parseUnits(r)
+	if err != nil {
+		logger.Error("Invalid units", "error", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	format, err := parseHeatmapFormat(r)
+	if err != nil {
+		logger.Error("Invalid format", "error", err)
+		status := http.StatusBadRequest
+		if errors.Is(err, errNotAcceptable) {
+			status = http.StatusNotAcceptable
+		}
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	includeErrors, err := parseBoolParam(r, "includeErrors")
+	if err != nil {
+		logger.Error("Invalid includeErrors", "error", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var area heatmapArea
+	switch {
+	case r.URL.Query().Has("bbox") && r.URL.Query().Has("shape"):
+		err = errors.New("shape applies only to heatmaps around lat and lon; a bbox is always scanned whole")
+	case r.URL.Query().Has("bbox"):
+		area, err = parseBBoxArea(r.URL.Query().Get("bbox"))
+	default:
+		area, err = parseCircleArea(r)
+	}
+	if err != nil {
+		logger.Error("Invalid heatmap area", "error", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Resolution is in degrees of latitude and longitude. Without one, the grid is sized to
+	// the area so it stays within the cell cap.
+	if v := r.URL.Query().Get("resolution"); v != "" {
+		resolution, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			logger.Error("Invalid resolution", "error", err)
+			writeJSONError(w, http.StatusBadRequest, "Invalid resolution")
+			return
+		}
+		area = area.withResolution(resolution)
+		if err := area.validateResolution(); err != nil {
+			logger.Error("Resolution out of range", "error", err)
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	} else {
+		area = autoResolution(area, s.config.HeatmapResolution, s.config.MaxHeatmapCells)
+	}
+	resolution := area.gridResolution()
+
+	heatmapRequests.Inc()
+	logger.Info("Handling heatmap data request", "area", area, "resolution", resolution, "units", units, "format", format)
+
+	// Refuse grids that would fan out into an unreasonable number of upstream calls
+	if cells := area.cellCount(); cells > s.config.MaxHeatmapCells {
+		logger.Error("Heatmap grid too large", "cells", cells, "max", s.config.MaxHeatmapCells)
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf(
+			"heatmap would scan %d cells, exceeding the maximum of %d; increase resolution or reduce the area",
+			cells, s.config.MaxHeatmapCells))
+		return
+	}
+
+	points := snapGridPoints(area.points(), resolution)
+	centerLat, centerLon := area.center()
+	fetchOpts := FetchOptions{Units: units, Exclude: currentExclude}
+
+	// The whole scan shares one deadline; cells not scored by then are left out and the
+	// partial grid is returned marked as truncated
+	scanCtx := r.Context()
+	if s.config.HeatmapTimeout > 0 {
+		var cancel context.CancelFunc
+		scanCtx, cancel = context.WithTimeout(scanCtx, s.config.HeatmapTimeout)
+		defer cancel()
+	}
+
+	var cacheKey string
+	var heatmapData []HeatmapData
+	cached, truncated := false, false
+	if s.heatmapCache != nil {
+		cacheKey = s.heatmapCache.key(area.cacheRegion(), resolution, units)
+		heatmapData, cached = s.heatmapCache.get(cacheKey)
+	}
+
+	// The body stays a bare array for existing clients, so the unit system and grid spacing
+	// travel in headers
+	w.Header().Set("X-Units", units)
+	w.Header().Set(heatmapResolutionHeader, strconv.FormatFloat(resolution, 'f', -1, 64))
+
+	switch {
+	case cached:
+		logger.Info("Heatmap served from disk cache", "datapoints", len(heatmapData))
+	case format == heatmapFormatCSV:
+		s.streamHeatmapCSV(scanCtx, w, points, fetchOpts, includeErrors, cacheKey, heatmapCSVFilename(centerLat, centerLon))
+		return
+	case format == heatmapFormatSSE:
+		s.streamHeatmapSSE(scanCtx, w, points, fetchOpts, includeErrors, cacheKey, resolution)
+		return
+	default:
+		heatmapData, truncated = s.scanHeatmap(scanCtx, points, fetchOpts, includeErrors)
+		logger.Info("Heatmap data calculated", "datapoints", len(heatmapData), "truncated", truncated)
+		s.cacheHeatmap(r.Context(), cacheKey, heatmapData, len(points), truncated)
+	}
+
+	if truncated {
+		w.Header().Set(heatmapTruncatedHeader, "true")
+	}
+	switch format {
+	case heatmapFormatGeoJSON:
+		collection := heatmapGeoJSON(heatmapData)
+		collection.Truncated = truncated
+		collection.Resolution = resolution
+		w.Header().Set("Content-Type", "application/geo+json")
+		writeBody(w, http.StatusOK, collection)
+	case heatmapFormatCSV:
+		setHeatmapCSVHeaders(w, heatmapCSVFilename(centerLat, centerLon))
+		w.WriteHeader(http.StatusOK)
+		cw := csv.NewWriter(w)
+		cw.Write(heatmapCSVHeader)
+		for _, cell := range heatmapData {
+			cw.Write(heatmapCSVRecord(cell))
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			logger.Error("Error writing CSV response", "error", err)
+		}
+	case heatmapFormatSSE:
+		writeHeatmapSSE(r.Context(), w, heatmapData, resolution, truncated)
+	default:
+		writeJSON(w, http.StatusOK, heatmapData)
+	}
+}
+
+// parseCircleArea reads the lat, lon and radius query parameters of a circular heatmap
+func parseCircleArea(r *http.Request) (circleArea, error) {
+	coords, err := coordinatesParam(r)
+	if err != nil {
+		return circleArea{}, err
+	}
+	rawRadius := r.URL.Query().Get("radius")
+	radius, err := strconv.ParseFloat(rawRadius, 64)
+	if err != nil {
+		return circleArea{}, fmt.Errorf("invalid radius %q; must be a finite, positive number of miles", rawRadius)
+	}
+	var square bool
+	switch shape := r.URL.Query().Get("shape"); shape {
+	case "", heatmapShapeCircle:
+	case heatmapShapeSquare:
+		square = true
+	default:
+		return circleArea{}, fmt.Errorf("invalid shape %q; must be %q or %q", shape, heatmapShapeCircle, heatmapShapeSquare)
+	}
+	if !(radius > 0) || math.IsInf(radius, 0) {
+		return circleArea{}, fmt.Errorf("radius %v is out of range; must be a finite, positive number of miles", radius)
+	}
+	return circleArea{lat: coords.Lat, lon: coords.Lon, radius: radius, square: square}, nil
+}
+
+// radiusDegrees is the radius in degrees of latitude
+func (a circleArea) radiusDegrees() float64 {
+	return a.radius / milesPerDegree
+}
+
+// gridResolution returns the spacing between grid points
+func (a circleArea) gridResolution() float64 {
+	return a.resolution
+}
+
+// withResolution returns the circle gridded at resolution
+func (a circleArea) withResolution(resolution float64) heatmapArea {
+	a.resolution = resolution
+	return a
+}
+
+// validateResolution requires a grid step narrower than the radius, since a step at least
+// as wide would sample little more than the center
+func (a circleArea) validateResolution() error {
+	if !(a.resolution > 0) || a.resolution >= a.radiusDegrees() {
+		return fmt.Errorf(
+			"resolution %v is out of range; must be greater than 0 and less than the radius in degrees (%.4f)",
+			a.resolution, a.radiusDegrees())
+	}
+	return nil
+}
+
+// cellCount returns how many grid points lie within the circle
+func (a circleArea) cellCount() int {
+	return heatmapCellCount(a.lat, a.radius, a.resolution, !a.square)
+}
+
+// points returns the grid points within the circle, dropping any past a pole
+func (a circleArea) points() []Coordinates {
+	center := Coordinates{Lat: a.lat, Lon: a.lon}
+	if a.square {
+		return walkGridPoints(center, a.radius, a.resolution, false)
+	}
+	return gridPoints(center, a.radius, a.resolution)
+}
+
+// cacheRegion quantizes the center and radius so nearby requests share a cache entry
+func (a circleArea) cacheRegion() string {
+	region := fmt.Sprintf("%.2f,%.2f,%.1f", a.lat, a.lon, a.radius)
+	if a.square {
+		region += "," + heatmapShapeSquare
+	}
+	return region
+}
+
+// center returns the circle's center
+func (a circleArea) center() (lat, lon float64) {
+	return a.lat, a.lon
+}
+
+// String describes the circle for logging
+func (a circleArea) String() string {
+	if a.square {
+		return fmt.Sprintf("%g mi square around %g,%g", a.radius, a.lat, a.lon)
+	}
+	return fmt.Sprintf("%g mi around %g,%g", a.radius, a.lat, a.lon)
+}
+
+// autoResolution grids an area for a request that gave no resolution. It starts from the
+// configured default, finer for a circle too small for it, and coarsens the grid until it
+// fits within maxCells.
+func autoResolution(area heatmapArea, def float64, maxCells int) heatmapArea {
+	resolution := def
+	if circle, ok := area.(circleArea); ok && resolution >= circle.radiusDegrees() {
+		resolution = roundResolution(circle.radiusDegrees() / 4)
+	}
+	area = area.withResolution(resolution)
+	for range maxAutoResolutionSteps {
+		cells := area.cellCount()
+		if cells <= maxCells {
+			break
+		}
+		// The cell count scales with the inverse square of the spacing
+		growth := math.Max(math.Sqrt(float64(cells)/float64(maxCells)), minAutoResolutionGrowth)
+		resolution = roundResolution(resolution * growth)
+		area = area.withResolution(resolution)
+	}
+	return area
+}
+
+// roundResolution rounds a computed grid spacing up to three significant digits so the
+// reported value stays readable
+func roundResolution(resolution float64) float64 {
+	scale := math.Pow(10, 2-math.Floor(math.Log10(resolution)))
+	return math.Ceil(resolution*scale) / scale
+}
+
+// minAutoResolutionGrowth is the least each step of autoResolution coarsens the grid by,
+// and maxAutoResolutionSteps bounds the search
+const (
+	minAutoResolutionGrowth = 1.05
+	maxAutoResolutionSteps  = 50
+)
+
+// cacheHeatmap stores a scan in the disk cache, if enabled. Only complete scans are cached
+// so a transient upstream failure isn't kept for the hour.
+func (s *Server) cacheHeatmap(ctx context.Context, key string, heatmapData []HeatmapData, cells int, truncated bool) {
+	if s.heatmapCache == nil || truncated || len(heatmapData) != cells {
+		return
+	}
+	for _, cell := range heatmapData {
+		if cell.Error != "" {
+			return
+		}
+	}
+	if err := s.heatmapCache.put(key, heatmapData); err != nil {
+		log.FromContext(ctx).Warn("Error caching heatmap", "error", err)
+	}
+}
+
+// heatmapMediaTypes maps the media type of each heatmap format to its format name, in the
+// order they are offered when negotiating with the Accept header
+var heatmapMediaTypes = []struct{ mediaType, format string }{
+	{mediaTypeJSON, heatmapFormatJSON},
+	{"application/geo+json", heatmapFormatGeoJSON},
+	{"text/csv", heatmapFormatCSV},
+	{"text/event-stream", heatmapFormatSSE},
+}
+
+// parseHeatmapFormat reads the optional format query parameter or, without one, negotiates
+// the format from the Accept header, defaulting to the plain JSON array. The error wraps
+// errNotAcceptable when the Accept header allows no format.
+func parseHeatmapFormat(r *http.Request) (string, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "":
+	case heatmapFormatJSON, heatmapFormatGeoJSON, heatmapFormatCSV, heatmapFormatSSE:
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid format %q; must be %q, %q, %q or %q", format,
+			heatmapFormatJSON, heatmapFormatGeoJSON, heatmapFormatCSV, heatmapFormatSSE)
+	}
+
+	offered := make([]string, len(heatmapMediaTypes))
+	for i, m := range heatmapMediaTypes {
+		offered[i] = m.mediaType
+	}
+	mediaType, err := negotiateMediaType(r, offered)
+	if err != nil {
+		return "", err
+	}
+	for _, m := range heatmapMediaTypes {
+		if m.mediaType == mediaType {
+			return m.format, nil
+		}
+	}
+	return heatmapFormatJSON, nil
+}
+
+// milesPerDegree is the approximate length of one degree of latitude, or of longitude at the equator
+const milesPerDegree = 69.0
+
+// minLonScale keeps the longitude extent finite for centers at or very near a pole
+const minLonScale = 0.01
+
+// gridExtent converts a radius in miles to half-widths in degrees of latitude and longitude
+// around a center at lat. A degree of longitude shrinks by cos(lat) away from the equator.
+func gridExtent(lat, radiusMiles float64) (latDegrees, lonDegrees float64) {
+	latDegrees = radiusMiles / milesPerDegree
+	lonScale := math.Max(math.Cos(degToRad(lat)), minLonScale)
+	lonDegrees = math.Min(radiusMiles/(milesPerDegree*lonScale), 180)
+	return latDegrees, lonDegrees
+}
+
+// gridPoints returns the points of a grid spaced resolution degrees apart that lie within
+// radiusMiles of center. It makes no network calls, so the grid math can be checked on its
+// own. Points beyond a pole are dropped and longitudes wrap across the antimeridian.
+func gridPoints(center Coordinates, radiusMiles, resolution float64) []Coordinates {
+	return walkGridPoints(center, radiusMiles, resolution, true)
+}
+
+// walkGridPoints collects the points visited by walkGrid around center
+func walkGridPoints(center Coordinates, radiusMiles, resolution float64, clip bool) []Coordinates {
+	var points []Coordinates
+	walkGrid(center.Lat, radiusMiles, resolution, clip, func(dlat, dlon float64) {
+		if pointLat := center.Lat + dlat; pointLat >= -90 && pointLat <= 90 {
+			points = append(points, Coordinates{Lat: pointLat, Lon: wrapLongitude(center.Lon + dlon)})
+		}
+	})
+	return points
+}
+
+// walkGrid calls fn with the degree offset of every grid point, spaced resolution degrees
+// apart, that lies within radiusMiles of a center at lat. Distance is measured in miles so
+// the scanned area is a true circle at any latitude. Without clip, every point of the
+// grid spanning radiusMiles either side of the center is visited. Offsets are whole
+// multiples of resolution counted out from the center, rather than accumulated by repeated
+// addition, so the grid is symmetric about the center and no edge row or column is lost to
+// floating-point drift.
+func walkGrid(lat, radiusMiles, resolution float64, clip bool, fn func(dlat, dlon float64)) {
+	latDegrees, lonDegrees := gridExtent(lat, radiusMiles)
+	latSteps, lonSteps := gridHalfSteps(latDegrees, resolution), gridHalfSteps(lonDegrees, resolution)
+	lonMiles := milesPerDegree * math.Cos(degToRad(lat))
+
+	for i := -latSteps; i <= latSteps; i++ {
+		dlat := float64(i) * resolution
+		for j := -lonSteps; j <= lonSteps; j++ {
+			dlon := float64(j) * resolution
+			// Check if the point is within the radius
+			northSouth := dlat * milesPerDegree
+			eastWest := dlon * lonMiles
+			if !clip || math.Sqrt(northSouth*northSouth+eastWest*eastWest) <= radiusMiles+gridEpsilon {
+				fn(dlat, dlon)
+			}
+		}
+	}
+}
+
+// gridHalfSteps returns how many whole steps of resolution fit within extent degrees on
+// one side of the center
+func gridHalfSteps(extent, resolution float64) int {
+	return int(math.Floor(extent/resolution + gridEpsilon))
+}
+
+// snapGridPoints moves each point to the nearest multiple of resolution and drops repeats.
+// Snapping makes the grid regular, so overlapping heatmaps sample the same coordinates.
+// Points that land on the same cell, such as -180 and 180 where the grid wraps across the
+// antimeridian, would each cost a redundant upstream call. Order is preserved.
+func snapGridPoints(points []Coordinates, resolution float64) []Coordinates {
+	type cell struct{ lat, lon int64 }
+	seen := make(map[cell]bool, len(points))
+	snapped := make([]Coordinates, 0, len(points))
+	for _, point := range points {
+		latIndex := snapIndex(point.Lat, resolution, 90)
+		lonIndex := snapIndex(point.Lon, resolution, 180)
+		lon := snapCoordinate(lonIndex, resolution)
+		if lon <= -180 {
+			// -180 and 180 are the same meridian
+			lon, lonIndex = 180, snapIndex(180, resolution, 180)
+		}
+		key := cell{latIndex, lonIndex}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		snapped = append(snapped, Coordinates{Lat: snapCoordinate(latIndex, resolution), Lon: lon})
+	}
+	return snapped
+}
+
+// snapIndex returns the multiple of resolution nearest v, stepping back toward zero if
+// rounding would carry it past ±limit
+func snapIndex(v, resolution, limit float64) int64 {
+	index := int64(math.Round(v / resolution))
+	if math.Abs(float64(index)*resolution) > limit+gridEpsilon {
+		if index > 0 {
+			index--
+		} else {
+			index++
+		}
+	}
+	return index
+}
+
+// snapCoordinate converts a grid index back to degrees, rounding away the float noise of
+// the multiplication so 3*0.1 reads as 0.3
+func snapCoordinate(index int64, resolution float64) float64 {
+	return math.Round(float64(index)*resolution*1e9) / 1e9
+}
+
+// wrapLongitude brings a longitude that crossed the antimeridian back into [-180, 180]
+func wrapLongitude(lon float64) float64 {
+	if lon > 180 {
+		return lon - 360
+	}
+	if lon < -180 {
+		return lon + 360
+	}
+	return lon
+}
+
+// exactCountLimit is the largest bounding box whose cells are counted one by one;
+// bigger grids are estimated instead so the check itself stays cheap
+const exactCountLimit = 1_000_000
+
+// heatmapCellCount returns how many grid points a scan would visit, clipped to the circle
+// when clip is set
+func heatmapCellCount(lat, radiusMiles, resolution float64, clip bool) int {
+	if !(resolution > 0) {
+		return math.MaxInt
+	}
+	latDegrees, lonDegrees := gridExtent(lat, radiusMiles)
+	// Computed in floating point since a tiny resolution can overflow an int
+	box := (2*math.Floor(latDegrees/resolution+gridEpsilon) + 1) * (2*math.Floor(lonDegrees/resolution+gridEpsilon) + 1)
+	if box > exactCountLimit {
+		if !clip {
+			return int(math.Min(box, math.MaxInt32))
+		}
+		// The circle covers about pi/4 of its bounding box
+		return int(math.Min(math.Pi/4*box, math.MaxInt32))
+	}
+
+	count := 0
+	walkGrid(lat, radiusMiles, resolution, clip, func(dlat, dlon float64) { count++ })
+	return count
+}
+
+// scanHeatmap scores every grid point using a bounded pool of workers. Results
+// keep the order of points. Cells whose fetch fails are left out unless
+// includeErrors is set, in which case every point is returned and failed or
+// unreached cells carry an Error. If ctx is canceled mid-scan, no more cells
+// are fetched and the partial results are returned with truncated set.
+func (s *Server) scanHeatmap(ctx context.Context, points []Coordinates, opts FetchOptions, includeErrors bool) (heatmapData []HeatmapData, truncated bool) {
+	results := make([]*HeatmapData, len(points))
+	truncated = s.scanHeatmapCells(ctx, points, opts, func(i int, cell HeatmapData) {
+		results[i] = &cell
+	})
+
+	for i, result := range results {
+		switch {
+		case result != nil && (result.Error == "" || includeErrors):
+			heatmapData = append(heatmapData, *result)
+		case result == nil && includeErrors:
+			heatmapData = append(heatmapData, HeatmapData{Lat: points[i].Lat, Lon: points[i].Lon, Error: notScanned})
+		}
+	}
+	return heatmapData, truncated
+}
+
+// scanHeatmapCells scores every grid point using a bounded pool of workers, calling emit
+// from the worker goroutines as each cell completes. Cells whose fetch fails are emitted
+// with Error set. It reports whether ctx was canceled before every cell was scored.
+func (s *Server) scanHeatmapCells(ctx context.Context, points []Coordinates, opts FetchOptions, emit func(i int, cell HeatmapData)) bool {
+	logger := log.FromContext(ctx)
+	err := forEachBounded(ctx, len(points), s.config.HeatmapConcurrency, func(i int) {
+		point := points[i]
+		weatherData, err := s.provider.CurrentAndHourly(ctx, point.Lat, point.Lon, opts)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("Error fetching weather data", "error", err, "lat", point.Lat, "lon", point.Lon)
+			}
+			emit(i, HeatmapData{Lat: point.Lat, Lon: point.Lon, Error: fmt.Sprintf("Error fetching weather data: %v", err)})
+			return
+		}
+
+		likelihood, _ := s.model.Score(ctx, weatherData.Current.conditions(point.Lat, point.Lon, opts.Units))
+		emit(i, HeatmapData{
+			Lat:        point.Lat,
+			Lon:        point.Lon,
+			Likelihood: likelihood,
+		})
+	})
+	if err == nil && ctx.Err() != nil {
+		// Every cell was dispatched but some in-flight fetches were cut short
+		err = ctx.Err()
+	}
+	if err != nil {
+		logger.Warn("Heatmap scan stopped early", "error", err)
+	}
+	return err != nil
+}
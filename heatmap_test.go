@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/charmbracelet/log"
+)
+
+// gridDistanceMiles is the planar distance walkGrid measures from center to point
+func gridDistanceMiles(center, point Coordinates) float64 {
+	dlon := point.Lon - center.Lon
+	if dlon > 180 {
+		dlon -= 360
+	} else if dlon < -180 {
+		dlon += 360
+	}
+	northSouth := (point.Lat - center.Lat) * milesPerDegree
+	eastWest := dlon * milesPerDegree * math.Cos(degToRad(center.Lat))
+	return math.Hypot(northSouth, eastWest)
+}
+
+func TestGridPointsCount(t *testing.T) {
+	equator := Coordinates{Lat: 0, Lon: 0}
+	tests := []struct {
+		name       string
+		radius     float64
+		resolution float64
+		want       int
+	}{
+		// Radius of one degree: the center and its four neighbors at the edge
+		{name: "resolution equal to radius", radius: milesPerDegree, resolution: 1, want: 5},
+		// Lattice points with i*i+j*j <= 4
+		{name: "two steps out", radius: milesPerDegree, resolution: 0.5, want: 13},
+		// Lattice points with i*i+j*j <= 16
+		{name: "four steps out", radius: milesPerDegree, resolution: 0.25, want: 49},
+		{name: "resolution coarser than radius", radius: milesPerDegree, resolution: 2, want: 1},
+		{name: "resolution just over radius", radius: milesPerDegree, resolution: 1.0001, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points := gridPoints(equator, tt.radius, tt.resolution)
+			if len(points) != tt.want {
+				t.Errorf("gridPoints returned %d points, want %d", len(points), tt.want)
+			}
+			if got := heatmapCellCount(equator.Lat, tt.radius, tt.resolution, true); got != len(points) {
+				t.Errorf("heatmapCellCount = %d, want %d to match gridPoints", got, len(points))
+			}
+		})
+	}
+}
+
+func TestGridPointsBounds(t *testing.T) {
+	tests := []struct {
+		name   string
+		center Coordinates
+		radius float64
+	}{
+		{name: "equator", center: Coordinates{Lat: 0, Lon: 0}, radius: 50},
+		{name: "mid latitude", center: Coordinates{Lat: 51.5, Lon: -0.12}, radius: 30},
+		{name: "near the north pole", center: Coordinates{Lat: 89.5, Lon: 10}, radius: 69},
+		{name: "near the south pole", center: Coordinates{Lat: -89.5, Lon: 10}, radius: 69},
+		{name: "across the antimeridian", center: Coordinates{Lat: 10, Lon: 179.5}, radius: 69},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points := gridPoints(tt.center, tt.radius, 0.25)
+			if len(points) == 0 {
+				t.Fatal("gridPoints returned no points")
+			}
+			for _, p := range points {
+				if p.Lat < -90 || p.Lat > 90 || p.Lon < -180 || p.Lon > 180 {
+					t.Fatalf("point %v is outside valid coordinates", p)
+				}
+				if d := gridDistanceMiles(tt.center, p); d > tt.radius+1e-6 {
+					t.Fatalf("point %v is %.3f miles from the center, beyond the %v mile radius", p, d, tt.radius)
+				}
+			}
+		})
+	}
+}
+
+func TestGridPointsWrapsAntimeridian(t *testing.T) {
+	points := gridPoints(Coordinates{Lat: 0, Lon: 179.5}, milesPerDegree, 1)
+	var wrapped bool
+	for _, p := range points {
+		if p.Lon < 0 {
+			wrapped = true
+			if math.Abs(p.Lon-(-179.5)) > 1e-9 {
+				t.Errorf("wrapped longitude = %v, want -179.5", p.Lon)
+			}
+		}
+	}
+	if !wrapped {
+		t.Error("no point wrapped past the antimeridian")
+	}
+}
+
+func TestWalkGridIsSymmetric(t *testing.T) {
+	type offset struct{ dlat, dlon float64 }
+	for _, lat := range []float64{0, 40.7, -63.2} {
+		for _, resolution := range []float64{0.1, 0.07, 0.3, 0.013} {
+			for _, clip := range []bool{true, false} {
+				visited := map[offset]bool{}
+				walkGrid(lat, 25, resolution, clip, func(dlat, dlon float64) {
+					visited[offset{dlat, dlon}] = true
+				})
+				if !visited[offset{0, 0}] {
+					t.Errorf("lat %v, resolution %v, clip %v: center not visited", lat, resolution, clip)
+				}
+				for o := range visited {
+					for _, mirror := range []offset{{-o.dlat, o.dlon}, {o.dlat, -o.dlon}, {-o.dlat, -o.dlon}} {
+						if !visited[mirror] {
+							t.Fatalf("lat %v, resolution %v, clip %v: offset %v visited but not its mirror %v",
+								lat, resolution, clip, o, mirror)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSnapGridPointsHasNoDuplicates(t *testing.T) {
+	tests := []struct {
+		name       string
+		points     []Coordinates
+		resolution float64
+	}{
+		{name: "grid off the lattice", points: gridPoints(Coordinates{Lat: 40.123, Lon: -74.456}, 30, 0.1), resolution: 0.1},
+		{name: "grid across the antimeridian", points: gridPoints(Coordinates{Lat: 0, Lon: 180}, milesPerDegree, 0.5), resolution: 0.5},
+		{name: "grid at the pole", points: gridPoints(Coordinates{Lat: 90, Lon: 0}, 100, 0.5), resolution: 0.5},
+		{name: "points a hair apart", points: []Coordinates{{Lat: 0.3, Lon: 1}, {Lat: 0.1 + 0.2, Lon: 1}, {Lat: 0.30000001, Lon: 1.00000001}}, resolution: 0.1},
+		{name: "both ends of the antimeridian", points: []Coordinates{{Lat: 5, Lon: 180}, {Lat: 5, Lon: -180}}, resolution: 0.25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapped := snapGridPoints(tt.points, tt.resolution)
+			seen := map[Coordinates]bool{}
+			for _, p := range snapped {
+				if seen[p] {
+					t.Fatalf("point %v appears more than once", p)
+				}
+				seen[p] = true
+				for _, v := range []float64{p.Lat, p.Lon} {
+					if steps := v / tt.resolution; math.Abs(steps-math.Round(steps)) > 1e-6 {
+						t.Fatalf("point %v is not on a multiple of %v", p, tt.resolution)
+					}
+				}
+				if p.Lat < -90 || p.Lat > 90 || p.Lon <= -180 || p.Lon > 180 {
+					t.Fatalf("point %v is outside valid coordinates", p)
+				}
+			}
+			if len(snapped) > len(tt.points) {
+				t.Errorf("snapping grew %d points to %d", len(tt.points), len(snapped))
+			}
+		})
+	}
+
+	if got := snapGridPoints([]Coordinates{{Lat: 0.3, Lon: 1}, {Lat: 0.1 + 0.2, Lon: 1}}, 0.1); len(got) != 1 {
+		t.Errorf("points a hair apart snapped to %v, want a single point", got)
+	}
+	if got := snapGridPoints([]Coordinates{{Lat: 5, Lon: 180}, {Lat: 5, Lon: -180}}, 0.25); len(got) != 1 || got[0].Lon != 180 {
+		t.Errorf("-180 and 180 snapped to %v, want the single point at 180", got)
+	}
+}
+
+func TestGridExtentWidensTowardThePoles(t *testing.T) {
+	tests := []struct {
+		name    string
+		lat     float64
+		radius  float64
+		wantLat float64
+		wantLon float64
+	}{
+		{name: "equator", lat: 0, radius: 69, wantLat: 1, wantLon: 1},
+		{name: "sixty north", lat: 60, radius: 69, wantLat: 1, wantLon: 2},
+		{name: "sixty south", lat: -60, radius: 69, wantLat: 1, wantLon: 2},
+		{name: "eighty north", lat: 80, radius: 69, wantLat: 1, wantLon: 1 / math.Cos(degToRad(80))},
+		// cos(lat) is floored at minLonScale so the extent stays finite at the pole
+		{name: "north pole", lat: 90, radius: 0.69, wantLat: 0.01, wantLon: 1},
+		{name: "capped at half the globe", lat: 89.9, radius: 500, wantLat: 500.0 / 69, wantLon: 180},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			latDegrees, lonDegrees := gridExtent(tt.lat, tt.radius)
+			if math.Abs(latDegrees-tt.wantLat) > 1e-9 {
+				t.Errorf("latitude extent = %v, want %v", latDegrees, tt.wantLat)
+			}
+			if math.Abs(lonDegrees-tt.wantLon) > 1e-9 {
+				t.Errorf("longitude extent = %v, want %v", lonDegrees, tt.wantLon)
+			}
+		})
+	}
+}
+
+func TestGridPointsCoverTheRadiusAtHighLatitude(t *testing.T) {
+	// At 60 degrees a degree of longitude is half as long, so the circle spans twice as
+	// many columns as rows
+	center := Coordinates{Lat: 60, Lon: 0}
+	points := gridPoints(center, milesPerDegree, 0.25)
+	var maxLat, maxLon float64
+	for _, p := range points {
+		maxLat = math.Max(maxLat, math.Abs(p.Lat-center.Lat))
+		maxLon = math.Max(maxLon, math.Abs(p.Lon-center.Lon))
+	}
+	if math.Abs(maxLat-1) > 1e-9 || math.Abs(maxLon-2) > 1e-9 {
+		t.Errorf("grid reaches %v degrees of latitude and %v of longitude, want 1 and 2", maxLat, maxLon)
+	}
+}
+
+// The grid benchmarks cover a city-wide heatmap at the default resolution, a few thousand cells
+var (
+	benchmarkCenter     = Coordinates{Lat: 40.7128, Lon: -74.006}
+	benchmarkRadius     = 40.0
+	benchmarkResolution = 0.05
+)
+
+func BenchmarkWalkGridPoints(b *testing.B) {
+	for _, clip := range []bool{true, false} {
+		name := "circle"
+		if !clip {
+			name = "square"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				walkGridPoints(benchmarkCenter, benchmarkRadius, benchmarkResolution, clip)
+			}
+		})
+	}
+}
+
+func BenchmarkSnapGridPoints(b *testing.B) {
+	points := gridPoints(benchmarkCenter, benchmarkRadius, benchmarkResolution)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		snapGridPoints(points, benchmarkResolution)
+	}
+}
+
+func BenchmarkHandleHeatmapData(b *testing.B) {
+	s := newTestServer(b, &countingProvider{data: testAfternoonWeather(b)})
+	// Every cell logs its score at info; a quieter logger keeps the benchmark to the scan itself
+	ctx := log.WithContext(context.Background(), log.NewWithOptions(io.Discard, log.Options{Level: log.WarnLevel}))
+	req := httptest.NewRequest(http.MethodGet, "/heatmap?lat=51.5&lon=-0.12&radius=10&resolution=0.025", nil).WithContext(ctx)
+	b.ReportAllocs()
+	for range b.N {
+		rec := httptest.NewRecorder()
+		s.handleHeatmapData(rec, req)
+		if rec.Code != http.StatusOK {
+			b.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestParseCircleAreaRadius(t *testing.T) {
+	tests := []struct {
+		radius  string
+		wantErr string
+	}{
+		{radius: "10"},
+		{radius: "0.5"},
+		{radius: "", wantErr: `invalid radius ""; must be a finite, positive number of miles`},
+		{radius: "ten", wantErr: `invalid radius "ten"; must be a finite, positive number of miles`},
+		{radius: "0", wantErr: "radius 0 is out of range; must be a finite, positive number of miles"},
+		{radius: "-5", wantErr: "radius -5 is out of range; must be a finite, positive number of miles"},
+		{radius: "Inf", wantErr: "radius +Inf is out of range; must be a finite, positive number of miles"},
+		{radius: "NaN", wantErr: "radius NaN is out of range; must be a finite, positive number of miles"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/heatmap?lat=51.5&lon=-0.12&radius="+url.QueryEscape(tt.radius), nil)
+		area, err := parseCircleArea(r)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("radius %q: unexpected error: %v", tt.radius, err)
+			} else if want, _ := strconv.ParseFloat(tt.radius, 64); area.radius != want {
+				t.Errorf("radius %q parsed as %v", tt.radius, area.radius)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.wantErr {
+			t.Errorf("radius %q: error = %v, want %q", tt.radius, err, tt.wantErr)
+		}
+	}
+}
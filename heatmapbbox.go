@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// bboxArea is the grid covering a bounding box, the form mapping UIs know their viewport in.
+// A box whose minLon is greater than its maxLon crosses the antimeridian.
+type bboxArea struct {
+	minLon, minLat, maxLon, maxLat, resolution float64
+}
+
+// parseBBoxArea reads a bbox query value of the form minLon,minLat,maxLon,maxLat
+func parseBBoxArea(v string) (bboxArea, error) {
+	parts := strings.Split(v, ",")
+	if len(parts) != 4 {
+		return bboxArea{}, errors.New("invalid bbox; must be minLon,minLat,maxLon,maxLat")
+	}
+	var values [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return bboxArea{}, fmt.Errorf("invalid bbox value %q; must be a number", part)
+		}
+		values[i] = f
+	}
+	area := bboxArea{minLon: values[0], minLat: values[1], maxLon: values[2], maxLat: values[3]}
+	if err := (Coordinates{Lat: area.minLat, Lon: area.minLon}).validate(); err != nil {
+		return bboxArea{}, err
+	}
+	if err := (Coordinates{Lat: area.maxLat, Lon: area.maxLon}).validate(); err != nil {
+		return bboxArea{}, err
+	}
+	if area.minLat > area.maxLat {
+		return bboxArea{}, fmt.Errorf("bbox minLat %v is greater than maxLat %v", area.minLat, area.maxLat)
+	}
+	return area, nil
+}
+
+// gridResolution returns the spacing between grid points
+func (a bboxArea) gridResolution() float64 {
+	return a.resolution
+}
+
+// withResolution returns the box gridded at resolution
+func (a bboxArea) withResolution(resolution float64) heatmapArea {
+	a.resolution = resolution
+	return a
+}
+
+// validateResolution requires a positive grid step; one wider than the box scans its corner
+func (a bboxArea) validateResolution() error {
+	if !(a.resolution > 0) || math.IsInf(a.resolution, 0) {
+		return fmt.Errorf("resolution %v is out of range; must be a finite number greater than 0", a.resolution)
+	}
+	return nil
+}
+
+// lonSpan is the box's width in degrees, measured eastward from minLon
+func (a bboxArea) lonSpan() float64 {
+	span := a.maxLon - a.minLon
+	if span < 0 {
+		span += 360
+	}
+	return span
+}
+
+// gridEpsilon absorbs floating-point error so an edge that is a whole number of steps away
+// isn't lost when, say, 0.3/0.1 comes out just under 3
+const gridEpsilon = 1e-9
+
+// steps returns how many grid rows and columns fit in the box
+func (a bboxArea) steps() (rows, cols float64) {
+	rows = math.Floor((a.maxLat-a.minLat)/a.resolution+gridEpsilon) + 1
+	cols = math.Floor(a.lonSpan()/a.resolution+gridEpsilon) + 1
+	return rows, cols
+}
+
+// cellCount returns how many grid points cover the box
+func (a bboxArea) cellCount() int {
+	rows, cols := a.steps()
+	return int(math.Min(rows*cols, math.MaxInt32))
+}
+
+// points returns the grid points covering the box, starting at its southwest corner
+func (a bboxArea) points() []Coordinates {
+	rows, cols := a.steps()
+	points := make([]Coordinates, 0, int(rows*cols))
+	for i := range int(rows) {
+		for j := range int(cols) {
+			points = append(points, Coordinates{
+				Lat: a.minLat + float64(i)*a.resolution,
+				Lon: wrapLongitude(a.minLon + float64(j)*a.resolution),
+			})
+		}
+	}
+	return points
+}
+
+// cacheRegion quantizes the corners so nearby viewports share a cache entry
+func (a bboxArea) cacheRegion() string {
+	return fmt.Sprintf("bbox:%.2f,%.2f,%.2f,%.2f", a.minLon, a.minLat, a.maxLon, a.maxLat)
+}
+
+// center returns the middle of the box
+func (a bboxArea) center() (lat, lon float64) {
+	return (a.minLat + a.maxLat) / 2, wrapLongitude(a.minLon + a.lonSpan()/2)
+}
+
+// String describes the box for logging
+func (a bboxArea) String() string {
+	return fmt.Sprintf("bbox %g,%g,%g,%g", a.minLon, a.minLat, a.maxLon, a.maxLat)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// heatmapCacheBucket is how long a cached heatmap stays fresh; entries are keyed by the
+// hour they were computed in
+const heatmapCacheBucket = time.Hour
+
+// heatmapDiskCache stores finished heatmap scans as JSON files so repeated requests for the
+// same area within the hour skip the upstream fan-out, even across restarts
+type heatmapDiskCache struct {
+	dir      string
+	maxBytes int
+	clock    Clock
+
+	// mu serializes eviction so concurrent writers don't race over the same files
+	mu sync.Mutex
+}
+
+// newHeatmapDiskCache creates a cache in dir, which is created if missing, holding at most maxBytes
+func newHeatmapDiskCache(dir string, maxBytes int, clock Clock) (*heatmapDiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating heatmap cache directory: %w", err)
+	}
+	return &heatmapDiskCache{dir: dir, maxBytes: maxBytes, clock: clock}, nil
+}
+
+// key identifies a scan by its quantized area, resolution, units and the current hour, so a
+// new hour naturally misses and recomputes
+func (c *heatmapDiskCache) key(region string, resolution float64, units string) string {
+	bucket := c.clock.Now().Truncate(heatmapCacheBucket).Unix()
+	raw := fmt.Sprintf("%s,%g,%s,%d", region, resolution, units, bucket)
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:16])
+}
+
+// path is the file holding the entry for key
+func (c *heatmapDiskCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// get returns the cached scan for key, if any
+func (c *heatmapDiskCache) get(key string) ([]HeatmapData, bool) {
+	body, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var data []HeatmapData
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// put stores a scan under key, then evicts stale and excess entries. The file is written
+// under a temporary name and renamed so readers never see a partial entry.
+func (c *heatmapDiskCache) put(key string, data []HeatmapData) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("encoding heatmap cache entry: %w", err)
+	}
+	tmp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating heatmap cache entry: %w", err)
+	}
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing heatmap cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing heatmap cache entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("storing heatmap cache entry: %w", err)
+	}
+	return c.evict()
+}
+
+// evict deletes entries from earlier hours, which can no longer be hit, then the oldest
+// remaining entries until the cache fits within maxBytes
+func (c *heatmapDiskCache) evict() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	type entry struct {
+		path    string
+		size    int
+		modTime time.Time
+	}
+	var entries []entry
+	total := 0
+	staleBefore := c.clock.Now().Truncate(heatmapCacheBucket)
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != c.dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		// Stale entries and temporary files abandoned by a crash are removed outright
+		if info.ModTime().Before(staleBefore) {
+			os.Remove(path)
+			return nil
+		}
+		if !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+		entries = append(entries, entry{path: path, size: int(info.Size()), modTime: info.ModTime()})
+		total += int(info.Size())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("scanning heatmap cache: %w", err)
+	}
+
+	slices.SortFunc(entries, func(a, b entry) int { return a.modTime.Compare(b.modTime) })
+	for _, e := range entries {
+		if total <= c.maxBytes {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("evicting heatmap cache entry: %w", err)
+		}
+		total -= e.size
+	}
+	return nil
+}
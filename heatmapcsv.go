@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/charmbracelet/log"
+)
+
+// heatmapCSVHeader is the first row of a CSV heatmap
+var heatmapCSVHeader = []string{"lat", "lon", "likelihood"}
+
+// heatmapCSVRecord formats a cell as a CSV row, leaving the likelihood empty for a failed cell
+func heatmapCSVRecord(cell HeatmapData) []string {
+	likelihood := strconv.FormatFloat(cell.Likelihood, 'f', -1, 64)
+	if cell.Error != "" {
+		likelihood = ""
+	}
+	return []string{
+		strconv.FormatFloat(cell.Lat, 'f', -1, 64),
+		strconv.FormatFloat(cell.Lon, 'f', -1, 64),
+		likelihood,
+	}
+}
+
+// heatmapCSVFilename names the download for a heatmap centered at lat/lon
+func heatmapCSVFilename(lat, lon float64) string {
+	return fmt.Sprintf("heatmap_%.4f_%.4f.csv", lat, lon)
+}
+
+// setHeatmapCSVHeaders marks the response as a CSV download
+func setHeatmapCSVHeaders(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+}
+
+// streamHeatmapCSV scans the grid and writes each row as soon as its cell is scored, so the
+// grid is never held in memory unless the disk cache needs it. Rows arrive in completion
+// order. Since the status is sent before the scan finishes, truncation is reported in the
+// X-Heatmap-Truncated trailer. Failed cells are written with an empty likelihood when
+// includeErrors is set and left out otherwise.
+func (s *Server) streamHeatmapCSV(ctx context.Context, w http.ResponseWriter, points []Coordinates, opts FetchOptions, includeErrors bool, cacheKey, filename string) {
+	logger := log.FromContext(ctx)
+	setHeatmapCSVHeaders(w, filename)
+	w.Header().Set("Trailer", heatmapTruncatedHeader)
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	cw.Write(heatmapCSVHeader)
+
+	// The cache keeps grid order, so with the cache enabled cells are also slotted by index
+	var ordered []HeatmapData
+	if s.heatmapCache != nil {
+		ordered = make([]HeatmapData, len(points))
+	}
+	var mu sync.Mutex
+	rows, failed := 0, 0
+	truncated := s.scanHeatmapCells(ctx, points, opts, func(i int, cell HeatmapData) {
+		mu.Lock()
+		defer mu.Unlock()
+		if cell.Error != "" {
+			failed++
+			if !includeErrors {
+				return
+			}
+		}
+		cw.Write(heatmapCSVRecord(cell))
+		cw.Flush()
+		rows++
+		if ordered != nil {
+			ordered[i] = cell
+		}
+	})
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		logger.Error("Error writing CSV response", "error", err)
+	}
+	if truncated {
+		w.Header().Set(heatmapTruncatedHeader, "true")
+	}
+	logger.Info("Heatmap data streamed", "datapoints", rows, "failed", failed, "truncated", truncated)
+	if rows == len(points) && failed == 0 {
+		s.cacheHeatmap(ctx, cacheKey, ordered, len(points), truncated)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// heatmapSSEProgressInterval is how often a progress event is sent during a streamed scan
+const heatmapSSEProgressInterval = time.Second
+
+// Event names in a streamed heatmap
+const (
+	// heatmapEventCell carries one scored HeatmapData cell
+	heatmapEventCell = "cell"
+	// heatmapEventProgress carries a HeatmapProgress while the scan runs
+	heatmapEventProgress = "progress"
+	// heatmapEventDone carries the final HeatmapProgress; the stream closes after it
+	heatmapEventDone = "done"
+)
+
+// HeatmapProgress reports how far a streamed heatmap scan has got
+type HeatmapProgress struct {
+	Done   int `json:"done"`
+	Total  int `json:"total"`
+	Failed int `json:"failed"`
+	// Resolution is the grid spacing in degrees
+	Resolution float64 `json:"resolution"`
+	// Truncated is set on the done event when the scan stopped before every cell was scored
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// sseWriter writes server-sent events, flushing each one straight to the client
+type sseWriter struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// newSSEWriter sends the event stream headers and returns a writer for the events
+func newSSEWriter(w http.ResponseWriter) *sseWriter {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stop proxies such as nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	return &sseWriter{w: w, rc: http.NewResponseController(w)}
+}
+
+// event writes v as the JSON data of a named event
+func (e *sseWriter) event(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return err
+	}
+	return e.rc.Flush()
+}
+
+// streamHeatmapSSE scans the grid and sends each cell as a server-sent event as soon as it
+// is scored, with a progress event every heatmapSSEProgressInterval and a done event at the
+// end. Cells arrive in completion order. A client that disconnects cancels ctx, which stops
+// the scan. Failed cells are sent with their Error when includeErrors is set and left out
+// otherwise.
+func (s *Server) streamHeatmapSSE(ctx context.Context, w http.ResponseWriter, points []Coordinates, opts FetchOptions, includeErrors bool, cacheKey string, resolution float64) {
+	logger := log.FromContext(ctx)
+	events := newSSEWriter(w)
+
+	// The cache keeps grid order, so with the cache enabled cells are also slotted by index
+	var ordered []HeatmapData
+	if s.heatmapCache != nil {
+		ordered = make([]HeatmapData, len(points))
+	}
+	var mu sync.Mutex
+	progress := HeatmapProgress{Total: len(points), Resolution: resolution}
+	sent := 0
+
+	stop := make(chan struct{})
+	var ticker sync.WaitGroup
+	ticker.Add(1)
+	go func() {
+		defer ticker.Done()
+		t := time.NewTicker(heatmapSSEProgressInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				mu.Lock()
+				events.event(heatmapEventProgress, progress)
+				mu.Unlock()
+			}
+		}
+	}()
+
+	truncated := s.scanHeatmapCells(ctx, points, opts, func(i int, cell HeatmapData) {
+		mu.Lock()
+		defer mu.Unlock()
+		progress.Done++
+		if cell.Error != "" {
+			progress.Failed++
+			if !includeErrors {
+				return
+			}
+		}
+		if err := events.event(heatmapEventCell, cell); err != nil {
+			return
+		}
+		sent++
+		if ordered != nil {
+			ordered[i] = cell
+		}
+	})
+	close(stop)
+	ticker.Wait()
+
+	progress.Truncated = truncated
+	// Only a disconnected client cancels ctx; a scan that ran out of time still reports
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		if err := events.event(heatmapEventDone, progress); err != nil {
+			logger.Error("Error writing heatmap event stream", "error", err)
+		}
+	}
+	logger.Info("Heatmap data streamed as events", "datapoints", sent, "failed", progress.Failed, "truncated", truncated)
+	if sent == len(points) && progress.Failed == 0 {
+		s.cacheHeatmap(ctx, cacheKey, ordered, len(points), truncated)
+	}
+}
+
+// writeHeatmapSSE sends an already computed heatmap as an event stream
+func writeHeatmapSSE(ctx context.Context, w http.ResponseWriter, heatmapData []HeatmapData, resolution float64, truncated bool) {
+	events := newSSEWriter(w)
+	progress := HeatmapProgress{Total: len(heatmapData), Resolution: resolution, Truncated: truncated}
+	for _, cell := range heatmapData {
+		if err := events.event(heatmapEventCell, cell); err != nil {
+			log.FromContext(ctx).Error("Error writing heatmap event stream", "error", err)
+			return
+		}
+		progress.Done++
+		if cell.Error != "" {
+			progress.Failed++
+		}
+	}
+	events.event(heatmapEventDone, progress)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage is used when the client accepts none of the supported languages
+const defaultLanguage = "en"
+
+// translations maps a language to the localized form of each English label. English needs
+// no entry; labels missing from a language fall back to English.
+var translations = map[string]map[string]string{
+	"es": {
+		messageLikely:   "Arcoíris posible",
+		messageUnlikely: "No se espera arcoíris",
+		"SSW":           "SSO",
+		"SW":            "SO",
+		"WSW":           "OSO",
+		"W":             "O",
+		"WNW":           "ONO",
+		"NW":            "NO",
+		"NNW":           "NNO",
+	},
+}
+
+// translate returns label in lang, or label itself when there is no translation
+func translate(lang, label string) string {
+	if localized, ok := translations[lang][label]; ok {
+		return localized
+	}
+	return label
+}
+
+// negotiateLanguage picks the supported language the client prefers most from its
+// Accept-Language header, matching on the primary subtag so "es-MX" selects Spanish
+func negotiateLanguage(r *http.Request) string {
+	best, bestQ := defaultLanguage, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, ok := translations[primary]; !ok && primary != defaultLanguage {
+			continue
+		}
+		q := 1.0
+		if name, value, ok := strings.Cut(params, "="); ok && strings.TrimSpace(name) == "q" {
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+				continue
+			}
+		}
+		// Earlier entries win ties, and a quality of zero means "not acceptable"
+		if q > bestQ {
+			best, bestQ = primary, q
+		}
+	}
+	return best
+}
+
+// setLanguageHeaders tells clients and caches which language the response is in
+func setLanguageHeaders(w http.ResponseWriter, lang string) {
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language")
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Conditions are the weather and position inputs used to score rainbow likelihood
+type Conditions struct {
+	Lat        float64
+	Lon        float64
+	Time       time.Time
+	Temp       float64
+	Humidity   int
+	Weather    []WeatherCondition
+	Clouds     int
+	UVI        float64
+	Visibility int
+	WindSpeed  float64
+	WindDeg    int
+	Pop        float64
+	// RainVolume and SnowVolume are the millimetres that fell over the last hour
+	RainVolume float64
+	SnowVolume float64
+	// Units is the unit system Temp and WindSpeed are expressed in
+	Units string
+}
+
+// LikelihoodWeights tunes how weather factors combine into a rainbow likelihood
+type LikelihoodWeights struct {
+	Cloud      float64
+	Humidity   float64
+	UVI        float64
+	Visibility float64
+	Wind       float64
+	// RainBoost multiplies the score when light rain is falling; other kinds of precipitation
+	// get a graduated share of it according to rainIntensity
+	RainBoost float64
+	// PopBoost multiplies the score when the probability of precipitation exceeds PopThreshold
+	PopBoost     float64
+	PopThreshold float64
+	// RainVolumeBoost multiplies the score when measurable rain fell over the last hour,
+	// scaled by the volume up to rainVolumeSaturation. It takes precedence over the
+	// condition-based boosts since it confirms rain is actually falling; 0 disables it.
+	RainVolumeBoost float64
+}
+
+// defaultLikelihoodWeights weights the five factors equally and boosts for rain, most of all
+// for rain that was actually measured
+var defaultLikelihoodWeights = LikelihoodWeights{
+	Cloud:           0.2,
+	Humidity:        0.2,
+	UVI:             0.2,
+	Visibility:      0.2,
+	Wind:            0.2,
+	RainBoost:       1.5,
+	PopBoost:        1.3,
+	PopThreshold:    0.5,
+	RainVolumeBoost: 1.8,
+}
+
+// validate reports an error if any weight is negative or every factor weight is zero
+func (w LikelihoodWeights) validate() error {
+	fields := []struct {
+		name  string
+		value float64
+	}{
+		{"cloud", w.Cloud},
+		{"humidity", w.Humidity},
+		{"uvi", w.UVI},
+		{"visibility", w.Visibility},
+		{"wind", w.Wind},
+		{"rain boost", w.RainBoost},
+		{"pop boost", w.PopBoost},
+		{"pop threshold", w.PopThreshold},
+		{"rain volume boost", w.RainVolumeBoost},
+	}
+	for _, f := range fields {
+		if f.value < 0 || math.IsNaN(f.value) || math.IsInf(f.value, 0) {
+			return fmt.Errorf("likelihood %s weight must be a non-negative number, got %v", f.name, f.value)
+		}
+	}
+	if w.Cloud+w.Humidity+w.UVI+w.Visibility+w.Wind == 0 {
+		return errors.New("at least one likelihood factor weight must be greater than zero")
+	}
+	return nil
+}
+
+// location returns the time zone of the forecast location, preferring the IANA zone and
+// falling back to the fixed UTC offset when the zone database doesn't know it
+func (d WeatherData) location() *time.Location {
+	if d.Timezone != "" {
+		if loc, err := time.LoadLocation(d.Timezone); err == nil {
+			return loc
+		}
+	}
+	return time.FixedZone(d.Timezone, d.TimezoneOffset)
+}
+
+// conditions converts the current weather at the given coordinates, reported in units, into scoring inputs
+func (c CurrentWeather) conditions(lat, lon float64, units string) Conditions {
+	return Conditions{
+		Lat:        lat,
+		Lon:        lon,
+		Time:       time.Unix(c.Dt, 0),
+		Temp:       c.Temp,
+		Units:      units,
+		Humidity:   c.Humidity,
+		Weather:    c.Weather,
+		Clouds:     c.Clouds,
+		UVI:        c.UVI,
+		Visibility: c.Visibility,
+		WindSpeed:  c.WindSpeed,
+		WindDeg:    c.WindDeg,
+		Pop:        0, // Current data doesn't have Pop, so we set it to 0
+		RainVolume: c.Rain.volume(),
+		SnowVolume: c.Snow.volume(),
+	}
+}
+
+// conditions converts an hourly forecast entry at the given coordinates, reported in units, into scoring inputs
+func (h HourlyWeather) conditions(lat, lon float64, units string) Conditions {
+	return Conditions{
+		Lat:        lat,
+		Lon:        lon,
+		Time:       time.Unix(h.Dt, 0),
+		Temp:       h.Temp,
+		Units:      units,
+		Humidity:   h.Humidity,
+		Weather:    h.Weather,
+		Clouds:     h.Clouds,
+		UVI:        h.UVI,
+		Visibility: h.Visibility,
+		WindSpeed:  h.WindSpeed,
+		WindDeg:    h.WindDeg,
+		Pop:        h.Pop,
+		RainVolume: h.Rain.volume(),
+		SnowVolume: h.Snow.volume(),
+	}
+}
+
+// conditions converts a daily forecast entry into scoring inputs evaluated at time t.
+// The daily block has no visibility, so it is assumed to be unrestricted.
+func (d DailyWeather) conditions(lat, lon float64, units string, t time.Time) Conditions {
+	return Conditions{
+		Lat:        lat,
+		Lon:        lon,
+		Time:       t,
+		Temp:       d.Temp.Day,
+		Units:      units,
+		Humidity:   d.Humidity,
+		Weather:    d.Weather,
+		Clouds:     d.Clouds,
+		UVI:        d.UVI,
+		Visibility: 10000,
+		WindSpeed:  d.WindSpeed,
+		WindDeg:    d.WindDeg,
+		Pop:        d.Pop,
+	}
+}
+
+// Temperatures bounding the transition from rain to frozen precipitation
+const (
+	// rainTempCelsius is the temperature at and above which precipitation is assumed to be rain
+	rainTempCelsius = 2.0
+	// frozenTempCelsius is the temperature at and below which precipitation is assumed to be frozen
+	frozenTempCelsius = -2.0
+)
+
+// tempCelsius returns the temperature in Celsius whatever unit system it was reported in
+func (c Conditions) tempCelsius() float64 {
+	if c.Units == unitsImperial {
+		return (c.Temp - 32) * 5 / 9
+	}
+	return c.Temp
+}
+
+// temperatureFactor is 1 for rain-warm temperatures, 0 once precipitation would be frozen,
+// and ramps linearly in between
+func temperatureFactor(celsius float64) float64 {
+	return clamp((celsius-frozenTempCelsius)/(rainTempCelsius-frozenTempCelsius), 0, 1)
+}
+
+// Kinds of bow the model can predict
+const (
+	bowTypeRainbow = "rainbow"
+	bowTypeMoonbow = "moonbow"
+)
+
+// LikelihoodFactors breaks a likelihood score down into the inputs that produced it. Each
+// factor is normalized to 0-1 before weighting.
+type LikelihoodFactors struct {
+	// Suitable is false when the reported weather can't produce a rainbow at all, in which
+	// case the remaining factors are not computed
+	Suitable         bool    `json:"suitable"`
+	WeatherID        int     `json:"weatherId,omitempty"`
+	CloudFactor      float64 `json:"cloudFactor"`
+	HumidityFactor   float64 `json:"humidityFactor"`
+	UVIFactor        float64 `json:"uviFactor"`
+	VisibilityFactor float64 `json:"visibilityFactor"`
+	WindFactor       float64 `json:"windFactor"`
+	// Multiplier is the rain or precipitation boost applied to the weighted sum, 1 when none was
+	Multiplier       float64 `json:"multiplier"`
+	MultiplierReason string  `json:"multiplierReason,omitempty"`
+	// RainVolume is the rain in millimetres over the last hour, when any was measured
+	RainVolume float64 `json:"rainVolume,omitempty"`
+	// TemperatureFactor damps the score near and below freezing, where precipitation falls as snow or sleet
+	TemperatureFactor float64 `json:"temperatureFactor"`
+	// LightFactor scales the score by how well the sun, or the moon at night, can light a bow
+	LightFactor float64 `json:"lightFactor"`
+}
+
+// Reasons given for boosts that don't come from the condition ID
+const (
+	// multiplierPrecipitation is the reason given when the precipitation probability boost applies
+	multiplierPrecipitation = "precipitation probability"
+	// multiplierRainVolume is the reason given when the measured rain volume boost applies
+	multiplierRainVolume = "measured rain"
+)
+
+// rainVolumeSaturation is the hourly rain in millimetres at which the rain volume boost is
+// fully applied; a passing shower barely wets a gauge, so anything from half a millimetre up counts
+const rainVolumeSaturation = 0.5
+
+// Weather condition IDs from minSuitableConditionID to maxSuitableConditionID are
+// precipitation that can produce a rainbow; the rest (clear, cloud, fog, dust) can't.
+// Snow codes start at minSnowConditionID.
+const (
+	minSuitableConditionID = 200
+	minSnowConditionID     = 600
+	maxSuitableConditionID = 699
+)
+
+// suitableCondition reports whether a condition can produce a rainbow at all. Falling snow
+// has no liquid drops to refract light, so of the snow codes only those mixing in rain count.
+func suitableCondition(id int) bool {
+	if id < minSuitableConditionID || id > maxSuitableConditionID {
+		return false
+	}
+	if id >= minSnowConditionID {
+		scale, _ := rainIntensity(id)
+		return scale > 0
+	}
+	return true
+}
+
+// precipitationRule gives the share of the full rain boost for condition IDs minID to maxID
+type precipitationRule struct {
+	minID, maxID int
+	scale        float64
+	condition    string
+}
+
+// precipitationRules rate how strongly each OpenWeatherMap condition favors a rainbow, as a
+// share of the full rain boost. Showers, which tend to fall from broken cloud with the sun
+// breaking through, rate highest; heavy and thunderstorm rain fall from thick overcast and
+// rate lower; conditions without liquid drops rate zero. Rules are checked in order, so
+// specific codes come before the catch-all for their group.
+// See https://openweathermap.org/weather-conditions for the codes.
+var precipitationRules = []precipitationRule{
+	// Thunderstorms: the rain under a storm cell rarely has sun on it
+	{200, 200, 0.6, "thunderstorm with light rain"},
+	{201, 201, 0.4, "thunderstorm with rain"},
+	{202, 202, 0.2, "thunderstorm with heavy rain"},
+	{230, 232, 0.4, "thunderstorm with drizzle"},
+	{200, 299, 0, "thunderstorm"},
+
+	// Drizzle: drops are small, giving paler bows
+	{300, 300, 0.6, "light drizzle"},
+	{301, 301, 0.7, "drizzle"},
+	{302, 302, 0.6, "heavy drizzle"},
+	{321, 321, 0.9, "shower drizzle"},
+	{300, 399, 0.7, "drizzle and rain"},
+
+	// Rain: showers are the classic sun-shower setup
+	{500, 500, 1, "light rain"},
+	{501, 501, 0.8, "moderate rain"},
+	{502, 504, 0.4, "heavy rain"},
+	{511, 511, 0.2, "freezing rain"},
+	{520, 520, 1.2, "light shower rain"},
+	{521, 521, 1.1, "shower rain"},
+	{531, 531, 1.1, "shower rain"},
+	{522, 522, 0.6, "heavy shower rain"},
+	{500, 599, 0.8, "rain"},
+
+	// Snow: only the mixed rain and snow codes carry any liquid drops
+	{615, 616, 0.2, "rain and snow"},
+	{600, 699, 0, "snow"},
+}
+
+// rainIntensity looks up an OpenWeatherMap condition ID in precipitationRules, returning its
+// share of the full rain boost and a description of the condition
+func rainIntensity(id int) (scale float64, condition string) {
+	for _, rule := range precipitationRules {
+		if id >= rule.minID && id <= rule.maxID {
+			return rule.scale, rule.condition
+		}
+	}
+	return 0, ""
+}
+
+// rainBoost scales the configured rain boost by a condition's intensity share
+func rainBoost(weights LikelihoodWeights, scale float64) float64 {
+	return 1 + (weights.RainBoost-1)*scale
+}
+
+// rainVolumeBoost returns the boost for the rain measured over the last hour. It reports
+// false when the boost is disabled, no rain was measured or more snow than rain fell.
+func rainVolumeBoost(weights LikelihoodWeights, weather Conditions) (float64, bool) {
+	if weights.RainVolumeBoost == 0 || weather.RainVolume <= 0 || weather.SnowVolume >= weather.RainVolume {
+		return 0, false
+	}
+	return 1 + (weights.RainVolumeBoost-1)*math.Min(weather.RainVolume/rainVolumeSaturation, 1), true
+}
+
+// calculateRainbowLikelihood computes the likelihood of a rainbow occurrence based on weather
+// conditions. It also reports whether the bow would be lit by the sun or, at night, the moon,
+// and the factors behind the score for callers that want to explain it.
+func calculateRainbowLikelihood(ctx context.Context, weather Conditions, weights LikelihoodWeights) (float64, string, LikelihoodFactors) {
+	logger := log.FromContext(ctx)
+	logger.Debug("Calculating rainbow likelihood", "weather_data", weather)
+	// Check if weather conditions are suitable for rainbow formation
+	if len(weather.Weather) == 0 {
+		logger.Debug("No weather conditions reported")
+		return 0, "", LikelihoodFactors{}
+	}
+	if !suitableCondition(weather.Weather[0].ID) {
+		logger.Debug("Weather conditions not suitable for rainbow", "weather_id", weather.Weather[0].ID)
+		return 0, "", LikelihoodFactors{WeatherID: weather.Weather[0].ID}
+	}
+
+	// Calculate factors affecting rainbow likelihood
+	cloudFactor := 1 - float64(weather.Clouds)/100
+	humidityFactor := float64(weather.Humidity) / 100
+	uviFactor := math.Min(weather.UVI/10, 1)                           // Normalize UVI to 0-1 range
+	visibilityFactor := math.Min(float64(weather.Visibility)/10000, 1) // Normalize visibility to 0-1 range
+	windFactor := 1 - math.Min(weather.WindSpeed/20, 1)                // Inverse wind speed factor
+
+	likelihood := weights.Cloud*cloudFactor +
+		weights.Humidity*humidityFactor +
+		weights.UVI*uviFactor +
+		weights.Visibility*visibilityFactor +
+		weights.Wind*windFactor
+
+	factors := LikelihoodFactors{
+		Suitable:         true,
+		WeatherID:        weather.Weather[0].ID,
+		CloudFactor:      cloudFactor,
+		HumidityFactor:   humidityFactor,
+		UVIFactor:        uviFactor,
+		VisibilityFactor: visibilityFactor,
+		WindFactor:       windFactor,
+		Multiplier:       1,
+	}
+
+	// Increase likelihood according to the rain measured over the last hour, or failing that
+	// the kind of precipitation falling or a high probability of precipitation
+	factors.RainVolume = weather.RainVolume
+	if boost, ok := rainVolumeBoost(weights, weather); ok {
+		logger.Debug("Adjusted likelihood for measured rain", "rain_mm", weather.RainVolume, "multiplier", boost)
+		factors.Multiplier, factors.MultiplierReason = boost, multiplierRainVolume
+	} else if scale, condition := rainIntensity(weather.Weather[0].ID); scale > 0 {
+		logger.Debug("Adjusted likelihood for precipitation", "weather_id", weather.Weather[0].ID, "condition", condition, "scale", scale)
+		factors.Multiplier, factors.MultiplierReason = rainBoost(weights, scale), condition
+	} else if weather.Pop > weights.PopThreshold {
+		logger.Debug("Increased likelihood due to high precipitation probability", "pop", weather.Pop)
+		factors.Multiplier, factors.MultiplierReason = weights.PopBoost, multiplierPrecipitation
+	}
+	likelihood *= factors.Multiplier
+
+	// Rainbows need liquid droplets, so damp the score as precipitation turns to ice
+	factors.TemperatureFactor = temperatureFactor(weather.tempCelsius())
+	logger.Debug("Applied temperature factor", "temp_c", weather.tempCelsius(), "factor", factors.TemperatureFactor)
+	likelihood *= factors.TemperatureFactor
+
+	// Rainbows only form with the sun low in the sky, opposite the observer. Once the sun
+	// has set, a bright moon can light a fainter moonbow instead.
+	kind := bowTypeRainbow
+	altitude := solarAltitude(weather.Lat, weather.Lon, weather.Time)
+	lightFactor := solarFactor(altitude)
+	if altitude <= 0 {
+		kind = bowTypeMoonbow
+		lightFactor = moonbowFactor(weather.Lat, weather.Lon, weather.Time)
+	}
+	logger.Debug("Applied light source factor", "kind", kind, "solar_altitude", altitude, "factor", lightFactor)
+	likelihood *= lightFactor
+	factors.LightFactor = lightFactor
+
+	// Ensure likelihood is not greater than 1
+	finalLikelihood := math.Min(likelihood, 1.0)
+	logger.Info("Rainbow likelihood calculated", "likelihood", finalLikelihood, "kind", kind)
+	return finalLikelihood, kind, factors
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// lowSunConditions are mild, damp conditions in London on an early June evening, with the
+// sun low in the west where rainbows are likely
+func lowSunConditions(weatherID int) Conditions {
+	return Conditions{
+		Lat:        51.5,
+		Lon:        -0.12,
+		Time:       time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC),
+		Temp:       15,
+		Humidity:   85,
+		Weather:    []WeatherCondition{{ID: weatherID}},
+		Clouds:     40,
+		UVI:        2,
+		Visibility: 10000,
+		WindSpeed:  4,
+		Units:      unitsMetric,
+	}
+}
+
+func TestCalculateRainbowLikelihood(t *testing.T) {
+	tests := []struct {
+		name         string
+		conditions   Conditions
+		wantSuitable bool
+		wantZero     bool
+		wantReason   string
+	}{
+		{name: "no weather reported", conditions: func() Conditions { c := lowSunConditions(0); c.Weather = nil; return c }(), wantZero: true},
+		{name: "clear sky", conditions: lowSunConditions(800), wantZero: true},
+		{name: "mist", conditions: lowSunConditions(701), wantZero: true},
+		{name: "light snow", conditions: lowSunConditions(600), wantZero: true},
+		{name: "heavy snow", conditions: lowSunConditions(602), wantZero: true},
+		{name: "sleet", conditions: lowSunConditions(611), wantZero: true},
+		{name: "light rain and snow", conditions: lowSunConditions(615), wantSuitable: true, wantReason: "rain and snow"},
+		{name: "light rain", conditions: lowSunConditions(500), wantSuitable: true, wantReason: "light rain"},
+		{name: "light shower rain", conditions: lowSunConditions(520), wantSuitable: true, wantReason: "light shower rain"},
+		{name: "drizzle", conditions: lowSunConditions(301), wantSuitable: true, wantReason: "drizzle"},
+		{name: "thunderstorm with light rain", conditions: lowSunConditions(200), wantSuitable: true, wantReason: "thunderstorm with light rain"},
+		{name: "sun below horizon without moon", conditions: func() Conditions {
+			c := lowSunConditions(500)
+			// New moon, so there is no moonbow either
+			c.Time = time.Date(2024, 6, 6, 23, 0, 0, 0, time.UTC)
+			return c
+		}(), wantSuitable: true, wantZero: true, wantReason: "light rain"},
+		{name: "frozen", conditions: func() Conditions { c := lowSunConditions(500); c.Temp = -5; return c }(), wantSuitable: true, wantZero: true, wantReason: "light rain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			likelihood, _, factors := calculateRainbowLikelihood(context.Background(), tt.conditions, defaultLikelihoodWeights)
+			if likelihood < 0 || likelihood > 1 {
+				t.Fatalf("likelihood = %v, want within [0, 1]", likelihood)
+			}
+			if (likelihood == 0) != tt.wantZero {
+				t.Errorf("likelihood = %v, want zero: %v", likelihood, tt.wantZero)
+			}
+			if factors.Suitable != tt.wantSuitable {
+				t.Errorf("suitable = %v, want %v", factors.Suitable, tt.wantSuitable)
+			}
+			if factors.MultiplierReason != tt.wantReason {
+				t.Errorf("multiplier reason = %q, want %q", factors.MultiplierReason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestCalculateRainbowLikelihoodWithoutWeather(t *testing.T) {
+	for name, weather := range map[string][]WeatherCondition{"nil": nil, "empty": {}} {
+		t.Run(name, func(t *testing.T) {
+			conditions := lowSunConditions(0)
+			conditions.Weather = weather
+			likelihood, kind, factors := calculateRainbowLikelihood(context.Background(), conditions, defaultLikelihoodWeights)
+			if likelihood != 0 || kind != "" {
+				t.Errorf("got likelihood %v and kind %q, want 0 and none", likelihood, kind)
+			}
+			if factors != (LikelihoodFactors{}) {
+				t.Errorf("factors = %+v, want none", factors)
+			}
+		})
+	}
+}
+
+func TestCalculateRainbowLikelihoodOrdersPrecipitation(t *testing.T) {
+	score := func(c Conditions) float64 {
+		likelihood, _, _ := calculateRainbowLikelihood(context.Background(), c, defaultLikelihoodWeights)
+		return likelihood
+	}
+
+	shower, light, heavy := score(lowSunConditions(520)), score(lowSunConditions(500)), score(lowSunConditions(502))
+	if !(shower > light && light > heavy) {
+		t.Errorf("shower %v, light rain %v, heavy rain %v; want showers above light rain above heavy rain", shower, light, heavy)
+	}
+
+	measured := lowSunConditions(500)
+	measured.RainVolume = 1
+	if got := score(measured); got <= light {
+		t.Errorf("measured rain scored %v, want above the light rain condition alone (%v)", got, light)
+	}
+}
+
+func TestSuitableCondition(t *testing.T) {
+	tests := []struct {
+		id   int
+		want bool
+	}{
+		{199, false},
+		{200, true},
+		{232, true},
+		{321, true},
+		{500, true},
+		{531, true},
+		{600, false},
+		{613, false},
+		{615, true},
+		{616, true},
+		{622, false},
+		{701, false},
+		{800, false},
+	}
+	for _, tt := range tests {
+		if got := suitableCondition(tt.id); got != tt.want {
+			t.Errorf("suitableCondition(%d) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkCalculateRainbowLikelihood(b *testing.B) {
+	// Scores are logged at info; a quieter logger keeps the benchmark to the model itself
+	ctx := log.WithContext(context.Background(), log.NewWithOptions(io.Discard, log.Options{Level: log.WarnLevel}))
+	conditions := lowSunConditions(500)
+	b.ReportAllocs()
+	for range b.N {
+		calculateRainbowLikelihood(ctx, conditions, defaultLikelihoodWeights)
+	}
+}
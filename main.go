@@ -1,25 +1,20 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
-	"math"
+	"context"
+	"errors"
 	"net/http"
-	"strconv"
-	"time"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
 
 	"github.com/charmbracelet/log"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// baseURL is the endpoint for the OpenWeatherMap API
-const (
-	baseURL = "https://api.openweathermap.org/data/3.0/onecall"
-)
-
-// apiKey is the authentication token for the OpenWeatherMap API
-var apiKey = "7d4c9a66d83ea191504f10e3e96afb23"
-
 // WeatherCondition represents a specific weather condition with its ID and description
 type WeatherCondition struct {
 	ID          int    `json:"id"`
@@ -28,293 +23,287 @@ type WeatherCondition struct {
 
 // WeatherData represents the structure of the weather data received from the API
 type WeatherData struct {
-	Current struct {
-		Dt         int64              `json:"dt"`
-		Temp       float64            `json:"temp"`
-		Humidity   int                `json:"humidity"`
-		Weather    []WeatherCondition `json:"weather"`
-		Clouds     int                `json:"clouds"`
-		UVI        float64            `json:"uvi"`
-		Visibility int                `json:"visibility"`
-		WindSpeed  float64            `json:"wind_speed"`
-		WindDeg    int                `json:"wind_deg"`
-	} `json:"current"`
-	Hourly []struct {
-		Dt         int64              `json:"dt"`
-		Temp       float64            `json:"temp"`
-		Humidity   int                `json:"humidity"`
-		Weather    []WeatherCondition `json:"weather"`
-		Clouds     int                `json:"clouds"`
-		UVI        float64            `json:"uvi"`
-		Visibility int                `json:"visibility"`
-		WindSpeed  float64            `json:"wind_speed"`
-		WindDeg    int                `json:"wind_deg"`
-		Pop        float64            `json:"pop"`
-	} `json:"hourly"`
+	Timezone       string          `json:"timezone"`
+	TimezoneOffset int             `json:"timezone_offset"`
+	Current        CurrentWeather  `json:"current"`
+	Hourly         []HourlyWeather `json:"hourly"`
+	Daily          []DailyWeather  `json:"daily"`
+	// Partial is set when the response was cut short and Hourly and Daily may be incomplete
+	Partial bool `json:"-"`
 }
 
-// RainbowPrediction represents the prediction result for rainbow occurrence
-type RainbowPrediction struct {
-	Likelihood float64 `json:"likelihood"`
-	Location   string  `json:"location"`
-	Time       string  `json:"time"`
+// CurrentWeather is the "current" block of a One Call response
+type CurrentWeather struct {
+	Dt         int64              `json:"dt"`
+	Sunrise    int64              `json:"sunrise"`
+	Sunset     int64              `json:"sunset"`
+	Temp       float64            `json:"temp"`
+	Humidity   int                `json:"humidity"`
+	Weather    []WeatherCondition `json:"weather"`
+	Clouds     int                `json:"clouds"`
+	UVI        float64            `json:"uvi"`
+	Visibility int                `json:"visibility"`
+	WindSpeed  float64            `json:"wind_speed"`
+	WindDeg    int                `json:"wind_deg"`
+	Rain       *Precipitation     `json:"rain,omitempty"`
+	Snow       *Precipitation     `json:"snow,omitempty"`
 }
 
-// HeatmapData represents the structure of the heatmap data
-type HeatmapData struct {
-	Lat        float64 `json:"lat"`
-	Lon        float64 `json:"lon"`
-	Likelihood float64 `json:"likelihood"`
+// HourlyWeather is a single entry of the "hourly" block of a One Call response
+type HourlyWeather struct {
+	Dt         int64              `json:"dt"`
+	Temp       float64            `json:"temp"`
+	Humidity   int                `json:"humidity"`
+	Weather    []WeatherCondition `json:"weather"`
+	Clouds     int                `json:"clouds"`
+	UVI        float64            `json:"uvi"`
+	Visibility int                `json:"visibility"`
+	WindSpeed  float64            `json:"wind_speed"`
+	WindDeg    int                `json:"wind_deg"`
+	Pop        float64            `json:"pop"`
+	Rain       *Precipitation     `json:"rain,omitempty"`
+	Snow       *Precipitation     `json:"snow,omitempty"`
 }
 
-// fetchWeatherData retrieves weather data from the OpenWeatherMap API for given coordinates
-func fetchWeatherData(lat, lon float64) (WeatherData, error) {
-	url := fmt.Sprintf("%s?lat=%f&lon=%f&exclude=hourly,daily&units=metric&appid=%s", baseURL, lat, lon, apiKey)
-	log.Debug("Fetching weather data", "url", url)
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Error("Error making request", "error", err)
-		return WeatherData{}, fmt.Errorf("error making request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		log.Error("API request failed", "status_code", resp.StatusCode)
-		return WeatherData{}, fmt.Errorf("API request failed with status code: %d", resp.StatusCode)
-	}
-
-	var weatherData WeatherData
-	if err := json.NewDecoder(resp.Body).Decode(&weatherData); err != nil {
-		log.Error("Error decoding response", "error", err)
-		return WeatherData{}, fmt.Errorf("error decoding response: %w", err)
-	}
-
-	log.Debug("Weather data fetched successfully", "data", weatherData)
-	return weatherData, nil
+// Precipitation is the "rain" or "snow" block of a One Call current or hourly entry,
+// present only when some fell
+type Precipitation struct {
+	// OneHour is the volume in millimetres over the last hour
+	OneHour float64 `json:"1h"`
 }
 
-// calculateRainbowLikelihood computes the likelihood of a rainbow occurrence based on weather conditions
-func calculateRainbowLikelihood(weather struct {
-	Temp       float64
-	Humidity   int
-	Weather    []WeatherCondition
-	Clouds     int
-	UVI        float64
-	Visibility int
-	WindSpeed  float64
-	WindDeg    int
-	Pop        float64
-}) float64 {
-	log.Debug("Calculating rainbow likelihood", "weather_data", weather)
-	// Check if weather conditions are suitable for rainbow formation
-	if len(weather.Weather) == 0 || weather.Weather[0].ID < 200 || weather.Weather[0].ID >= 700 {
-		log.Debug("Weather conditions not suitable for rainbow", "weather_id", weather.Weather[0].ID)
+// volume returns the hourly volume in millimetres, 0 when none was reported
+func (p *Precipitation) volume() float64 {
+	if p == nil {
 		return 0
 	}
+	return p.OneHour
+}
 
-	// Calculate factors affecting rainbow likelihood
-	cloudFactor := 1 - float64(weather.Clouds)/100
-	humidityFactor := float64(weather.Humidity) / 100
-	uviFactor := math.Min(weather.UVI/10, 1)                           // Normalize UVI to 0-1 range
-	visibilityFactor := math.Min(float64(weather.Visibility)/10000, 1) // Normalize visibility to 0-1 range
-	windFactor := 1 - math.Min(weather.WindSpeed/20, 1)                // Inverse wind speed factor
-
-	likelihood := (cloudFactor + humidityFactor + uviFactor + visibilityFactor + windFactor) / 5
-
-	// Increase likelihood if there's rain or high probability of precipitation
-	if weather.Weather[0].ID >= 300 && weather.Weather[0].ID < 600 {
-		log.Debug("Increased likelihood due to rain", "weather_id", weather.Weather[0].ID)
-		likelihood *= 1.5
-	} else if weather.Pop > 0.5 {
-		log.Debug("Increased likelihood due to high precipitation probability", "pop", weather.Pop)
-		likelihood *= 1.3
-	}
+// DailyWeather is a single entry of the "daily" block of a One Call response
+type DailyWeather struct {
+	Dt      int64 `json:"dt"`
+	Sunrise int64 `json:"sunrise"`
+	Sunset  int64 `json:"sunset"`
+	Temp    struct {
+		Day float64 `json:"day"`
+		Min float64 `json:"min"`
+		Max float64 `json:"max"`
+	} `json:"temp"`
+	Humidity  int                `json:"humidity"`
+	Weather   []WeatherCondition `json:"weather"`
+	Clouds    int                `json:"clouds"`
+	UVI       float64            `json:"uvi"`
+	WindSpeed float64            `json:"wind_speed"`
+	WindDeg   int                `json:"wind_deg"`
+	Pop       float64            `json:"pop"`
+}
 
-	// Ensure likelihood is not greater than 1
-	finalLikelihood := math.Min(likelihood, 1.0)
-	log.Info("Rainbow likelihood calculated", "likelihood", finalLikelihood)
-	return finalLikelihood
+// Server holds the dependencies shared by the HTTP handlers
+type Server struct {
+	config    Config
+	provider  WeatherProvider
+	geocoder  Geocoder
+	readiness *readinessChecker
+	clock     Clock
+	model     LikelihoodModel
+	streams   *streamHub
+	// heatmapCache is nil when the heatmap disk cache is disabled
+	heatmapCache *heatmapDiskCache
+	inFlight     atomic.Int64
 }
 
-// handlePrediction processes the prediction request and returns the rainbow prediction
-func handlePrediction(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	lat, err := strconv.ParseFloat(vars["lat"], 64)
+func main() {
+	log.Info("Initializing rainbow prediction server", "version", version, "commit", commit)
+	cfg, err := loadConfig()
 	if err != nil {
-		log.Error("Invalid latitude", "error", err)
-		http.Error(w, "Invalid latitude", http.StatusBadRequest)
-		return
+		log.Fatal("Invalid configuration", "error", err)
 	}
-	lon, err := strconv.ParseFloat(vars["lon"], 64)
-	if err != nil {
-		log.Error("Invalid longitude", "error", err)
-		http.Error(w, "Invalid longitude", http.StatusBadRequest)
-		return
+	// Debug logging includes full weather payloads, so it is opt-in
+	log.SetLevel(cfg.LogLevel)
+	log.Debug("API keys configured", "count", len(cfg.APIKeys))
+	apiKeysConfigured.Set(float64(len(cfg.APIKeys)))
+	if cfg.UpstreamBaseURL != defaultBaseURL || cfg.GeocodeBaseURL != defaultGeocodeURL {
+		log.Info("Using custom OpenWeatherMap endpoints", "upstream", cfg.UpstreamBaseURL, "geocode", cfg.GeocodeBaseURL)
 	}
-
-	log.Info("Handling prediction request", "latitude", lat, "longitude", lon)
-
-	weatherData, err := fetchWeatherData(lat, lon)
+	clock := systemClock{}
+	// Every upstream request goes through the shared client, so this names the server on all of them
+	httpClient.Transport = userAgentTransport{userAgent: cfg.UserAgent, next: http.DefaultTransport}
+	owm := NewOpenWeatherMapProvider(cfg, clock)
+	if cfg.Check {
+		// Runs ahead of snapshots so the check always reaches the real endpoint
+		if !runCheck(context.Background(), owm) {
+			os.Exit(1)
+		}
+		log.Info("Every API key was accepted")
+		os.Exit(0)
+	}
+	if cfg.SnapshotMode != snapshotOff {
+		transport, err := newSnapshotTransport(cfg.SnapshotMode, cfg.SnapshotDir, httpClient.Transport)
+		if err != nil {
+			log.Fatal("Error setting up upstream snapshots", "error", err)
+		}
+		owm.client = &http.Client{Timeout: httpClient.Timeout, Transport: transport}
+		log.Info("Upstream snapshots enabled", "mode", cfg.SnapshotMode, "dir", cfg.SnapshotDir)
+	}
+	model, err := newLikelihoodModel(cfg)
 	if err != nil {
-		log.Error("Error fetching weather data", "error", err)
-		http.Error(w, fmt.Sprintf("Error fetching weather data: %v", err), http.StatusInternalServerError)
-		return
+		log.Fatal("Invalid configuration", "error", err)
 	}
-
-	var bestLikelihood float64
-	var bestTime time.Time
-
-	// Find the time with the highest rainbow likelihood
-	for _, hourly := range weatherData.Hourly {
-		likelihood := calculateRainbowLikelihood(struct {
-			Temp       float64
-			Humidity   int
-			Weather    []WeatherCondition
-			Clouds     int
-			UVI        float64
-			Visibility int
-			WindSpeed  float64
-			WindDeg    int
-			Pop        float64
-		}{
-			Temp:       hourly.Temp,
-			Humidity:   hourly.Humidity,
-			Weather:    hourly.Weather,
-			Clouds:     hourly.Clouds,
-			UVI:        hourly.UVI,
-			Visibility: hourly.Visibility,
-			WindSpeed:  hourly.WindSpeed,
-			WindDeg:    hourly.WindDeg,
-			Pop:        hourly.Pop,
-		})
-
-		if likelihood > bestLikelihood {
-			bestLikelihood = likelihood
-			bestTime = time.Unix(hourly.Dt, 0)
+	log.Info("Likelihood model selected", "model", cfg.LikelihoodModel)
+
+	// CORS wraps the router so preflight OPTIONS requests are answered before route matching
+	cors := newCORSPolicy(cfg.CORSAllowedOrigins, cfg.CORSAllowedMethods, cfg.CORSAllowedHeaders)
+
+	weatherCache := NewCachingProvider(owm, cfg.CacheTTL, cfg.CachePrecision, clock)
+	s := &Server{
+		config:    cfg,
+		provider:  weatherCache,
+		geocoder:  NewCachingGeocoder(owm, cfg.GeocodeCacheTTL, cfg.GeocodeCacheSize, clock),
+		readiness: newReadinessChecker(owm, cfg.ReadyCacheTTL, clock),
+		clock:     clock,
+		model:     model,
+		streams:   newStreamHub(cfg, cors),
+	}
+	if cfg.HeatmapCacheDir != "" {
+		if s.heatmapCache, err = newHeatmapDiskCache(cfg.HeatmapCacheDir, cfg.HeatmapCacheMaxBytes, clock); err != nil {
+			log.Fatal("Invalid configuration", "error", err)
 		}
+		log.Info("Heatmap disk cache enabled", "dir", cfg.HeatmapCacheDir, "max_bytes", cfg.HeatmapCacheMaxBytes)
 	}
+	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusNotFound, "Not found")
+	})
+	r.MethodNotAllowedHandler = methodNotAllowed(r)
+
+	// Serve static files
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		log.FromContext(r.Context()).Debug("Serving index.html")
+		http.ServeFile(w, r, filepath.Join(cfg.StaticDir, "index.html"))
+	})
 
-	// Create the prediction result
-	prediction := RainbowPrediction{
-		Likelihood: bestLikelihood,
-		Location:   fmt.Sprintf("%.4f, %.4f", lat, lon),
-		Time:       bestTime.Format(time.RFC3339),
+	// Measure every route and expose the results for Prometheus
+	r.Use(instrument)
+	// Recover inside instrument so a panicking handler is counted as the 500 it returns
+	r.Use(recoverPanics)
+	r.Use(limitRequestBody(cfg.MaxRequestBodyBytes))
+	if len(cfg.APITokens) > 0 {
+		log.Info("API token authentication enabled", "tokens", len(cfg.APITokens))
+		r.Use(newTokenAuth(cfg.APITokens).middleware)
 	}
+	if cfg.RateLimit > 0 {
+		r.Use(newRateLimiter(cfg.RateLimit, cfg.RateLimitBurst, clock).middleware)
+	}
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
 
-	log.Info("Prediction calculated", "prediction", prediction)
+	// Debug route showing how the weather cache groups coordinates
+	r.HandleFunc("/debug/cache", s.handleCacheDebug).Methods("GET")
 
-	// Send the prediction as JSON response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(prediction)
-}
+	// Machine-readable API description
+	r.HandleFunc("/openapi.json", s.handleOpenAPI).Methods("GET")
 
-// handleHeatmapData processes the heatmap data request
-func handleHeatmapData(w http.ResponseWriter, r *http.Request) {
-	lat, err := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
-	if err != nil {
-		log.Error("Invalid latitude", "error", err)
-		http.Error(w, "Invalid latitude", http.StatusBadRequest)
-		return
-	}
-	lon, err := strconv.ParseFloat(r.URL.Query().Get("lon"), 64)
-	if err != nil {
-		log.Error("Invalid longitude", "error", err)
-		http.Error(w, "Invalid longitude", http.StatusBadRequest)
-		return
-	}
-	radius, err := strconv.ParseFloat(r.URL.Query().Get("radius"), 64)
-	if err != nil {
-		log.Error("Invalid radius", "error", err)
-		http.Error(w, "Invalid radius", http.StatusBadRequest)
-		return
-	}
-	resolution, err := strconv.ParseFloat(r.URL.Query().Get("resolution"), 64)
-	if err != nil {
-		resolution = 0.05 // Default resolution if not provided or invalid
-	}
+	// Build metadata for deployment tracking
+	r.HandleFunc("/version", s.handleVersion).Methods("GET")
 
-	log.Info("Handling heatmap data request", "lat", lat, "lon", lon, "radius", radius, "resolution", resolution)
-
-	var heatmapData []HeatmapData
-
-	// Convert radius from miles to degrees (approximate)
-	radiusDegrees := radius / 69 // 1 degree is approximately 69 miles
-
-	for dlat := -radiusDegrees; dlat <= radiusDegrees; dlat += resolution {
-		for dlon := -radiusDegrees; dlon <= radiusDegrees; dlon += resolution {
-			pointLat := lat + dlat
-			pointLon := lon + dlon
-
-			// Check if the point is within the radius
-			if math.Sqrt(dlat*dlat+dlon*dlon) <= radiusDegrees {
-				weatherData, err := fetchWeatherData(pointLat, pointLon)
-				if err != nil {
-					log.Error("Error fetching weather data", "error", err, "lat", pointLat, "lon", pointLon)
-					continue
-				}
-
-				likelihood := calculateRainbowLikelihood(struct {
-					Temp       float64
-					Humidity   int
-					Weather    []WeatherCondition
-					Clouds     int
-					UVI        float64
-					Visibility int
-					WindSpeed  float64
-					WindDeg    int
-					Pop        float64
-				}{
-					Temp:       weatherData.Current.Temp,
-					Humidity:   weatherData.Current.Humidity,
-					Weather:    weatherData.Current.Weather,
-					Clouds:     weatherData.Current.Clouds,
-					UVI:        weatherData.Current.UVI,
-					Visibility: weatherData.Current.Visibility,
-					WindSpeed:  weatherData.Current.WindSpeed,
-					WindDeg:    weatherData.Current.WindDeg,
-					Pop:        0, // Current data doesn't have Pop, so we set it to 0
-				})
-				heatmapData = append(heatmapData, HeatmapData{
-					Lat:        pointLat,
-					Lon:        pointLon,
-					Likelihood: likelihood,
-				})
-			}
-		}
-	}
+	// How weather conditions map to likelihood adjustments
+	r.HandleFunc("/conditions", s.handleConditions).Methods("GET")
 
-	log.Info("Heatmap data calculated", "datapoints", len(heatmapData))
+	// Health checks for load balancers and orchestrators
+	r.HandleFunc("/healthz", s.handleHealthz).Methods("GET")
+	r.HandleFunc("/readyz", s.handleReadyz).Methods("GET")
 
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(heatmapData)
-	if err != nil {
-		log.Error("Error encoding JSON response", "error", err)
-		http.Error(w, "Error encoding response", http.StatusInternalServerError)
-		return
-	}
-}
+	// API route for prediction by city name. Named routes are registered ahead of
+	// /predict/{lat}/{lon}, which would otherwise match them first.
+	r.HandleFunc("/predict/city/{name}", s.handleCityPrediction).Methods("GET")
+	// API route for prediction by postal code
+	r.HandleFunc("/predict/zip/{code}", s.handleZipPrediction).Methods("GET")
 
-func main() {
-	// Set logging level to Debug for detailed logs
-	log.SetLevel(log.DebugLevel)
-	log.Info("Initializing rainbow prediction server")
-	log.Debug("API Key", "key", apiKey)
-	r := mux.NewRouter()
+	// API route for prediction, by path or query parameters
+	r.HandleFunc("/predict/{lat}/{lon}", s.handlePrediction).Methods("GET")
+	r.HandleFunc("/predict", s.handlePrediction).Methods("GET")
 
-	// Serve static files
-	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		log.Debug("Serving index.html")
-		http.ServeFile(w, r, "index.html")
-	})
+	// API route for prediction from the current conditions alone
+	r.HandleFunc("/predict/now/{lat}/{lon}", s.handleCurrentPrediction).Methods("GET")
+
+	// API route for predicting many locations in one request
+	r.HandleFunc("/predict/batch", s.handleBatchPrediction).Methods("POST")
+
+	// API route ranking named locations by rainbow likelihood
+	r.HandleFunc("/compare", s.handleCompare).Methods("POST")
 
-	// API route for prediction
-	r.HandleFunc("/predict/{lat}/{lon}", handlePrediction).Methods("GET")
+	// WebSocket route pushing live prediction updates
+	r.HandleFunc("/ws/predict/{lat}/{lon}", s.handlePredictionStream).Methods("GET")
+
+	// API route for the daily rainbow forecast
+	r.HandleFunc("/forecast/daily/{lat}/{lon}", s.handleDailyForecast).Methods("GET")
 
 	// API route for heatmap data
-	r.HandleFunc("/heatmap", handleHeatmapData).Methods("GET")
+	r.HandleFunc("/heatmap", s.handleHeatmapData).Methods("GET")
 
 	// Start the server
-	port := 8080
-	log.Info("Server starting", "url", fmt.Sprintf("http://localhost:%d", port))
-	log.Fatal("Server stopped", "error", http.ListenAndServe(fmt.Sprintf(":%d", port), r))
+	srv := &http.Server{
+		Addr:    cfg.Addr,
+		Handler: s.trackInFlight(withRequestID(accessLog(cors.middleware(withGzip(r))))),
+	}
+	srv.RegisterOnShutdown(s.streams.close)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		log.Info("Server starting", "addr", cfg.Addr, "static_dir", cfg.StaticDir, "tls", cfg.tlsEnabled())
+		var err error
+		if cfg.tlsEnabled() {
+			err = srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Server stopped", "error", err)
+		}
+	}()
+
+	// Plain HTTP clients are pointed at the HTTPS listener
+	var redirectSrv *http.Server
+	if cfg.HTTPRedirectAddr != "" {
+		redirectSrv = &http.Server{Addr: cfg.HTTPRedirectAddr, Handler: httpsRedirect(cfg.Addr)}
+		go func() {
+			log.Info("HTTP redirect starting", "addr", cfg.HTTPRedirectAddr)
+			if err := redirectSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal("HTTP redirect stopped", "error", err)
+			}
+		}()
+	}
+
+	// Keep the configured locations' weather cached; the warmer stops with ctx
+	warmerDone := make(chan struct{})
+	if len(cfg.WarmLocations) > 0 {
+		warmer := &cacheWarmer{cache: weatherCache, locations: cfg.WarmLocations, units: cfg.WarmUnits, interval: cfg.WarmInterval}
+		go func() {
+			defer close(warmerDone)
+			warmer.run(ctx)
+		}()
+	} else {
+		close(warmerDone)
+	}
+
+	<-ctx.Done()
+	stop()
+	<-warmerDone
+
+	// Give in-flight requests such as heatmap scans a chance to finish
+	log.Info("Shutting down server", "in_flight", s.inFlight.Load(), "grace_period", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if redirectSrv != nil {
+		if err := redirectSrv.Shutdown(shutdownCtx); err != nil {
+			log.Error("HTTP redirect shutdown did not complete", "error", err)
+		}
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Error("Graceful shutdown did not complete", "error", err, "in_flight", s.inFlight.Load())
+		return
+	}
+	log.Info("Server stopped")
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// httpRequests counts handled requests by route template, method and status code
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rainbows_http_requests_total",
+		Help: "Total HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	// httpRequestDuration tracks how long each route takes to respond
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rainbows_http_request_duration_seconds",
+		Help:    "Time taken to serve HTTP requests, by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+
+	// predictionRequests counts requests for single-location predictions
+	predictionRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rainbows_prediction_requests_total",
+		Help: "Total rainbow prediction requests.",
+	})
+
+	// predictionLikelihood tracks the distribution of returned likelihoods by basis, best or now
+	predictionLikelihood = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rainbows_prediction_likelihood",
+		Help:    "Likelihood of returned rainbow predictions, by basis.",
+		Buckets: []float64{0, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1},
+	}, []string{"basis"})
+
+	// zeroLikelihoodPredictions counts predictions telling the caller no rainbow is possible
+	zeroLikelihoodPredictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rainbows_prediction_zero_likelihood_total",
+		Help: "Returned rainbow predictions with a likelihood of zero, by basis.",
+	}, []string{"basis"})
+
+	// heatmapRequests counts requests for heatmap scans
+	heatmapRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rainbows_heatmap_requests_total",
+		Help: "Total heatmap requests.",
+	})
+
+	// upstreamLatency tracks the round-trip time of OpenWeatherMap calls
+	upstreamLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rainbows_upstream_request_duration_seconds",
+		Help:    "Latency of OpenWeatherMap API calls.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	// upstreamErrors counts failed OpenWeatherMap calls by HTTP status code,
+	// "network"/"decode" when no usable response was received, or "partial" when
+	// only part of a malformed response could be used
+	upstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rainbows_upstream_errors_total",
+		Help: "Failed OpenWeatherMap API calls, by status code.",
+	}, []string{"code"})
+
+	// upstreamCircuitState reports the upstream circuit breaker state: 0 closed, 1 half-open, 2 open
+	upstreamCircuitState = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rainbows_upstream_circuit_state",
+		Help: "State of the OpenWeatherMap circuit breaker: 0 closed, 1 half-open, 2 open.",
+	})
+
+	// upstreamCircuitRejections counts calls failed fast by the open circuit breaker
+	upstreamCircuitRejections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rainbows_upstream_circuit_rejections_total",
+		Help: "OpenWeatherMap calls refused without being made because the circuit breaker was open.",
+	})
+
+	// apiKeysConfigured reports how many OpenWeatherMap API keys are in rotation
+	apiKeysConfigured = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rainbows_upstream_api_keys",
+		Help: "Number of configured OpenWeatherMap API keys.",
+	})
+
+	// apiKeysBenched counts keys taken out of rotation after being refused, by reason
+	apiKeysBenched = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rainbows_upstream_api_key_benched_total",
+		Help: "Times an OpenWeatherMap API key was temporarily skipped, by reason.",
+	}, []string{"reason"})
+)
+
+// observePrediction records a returned prediction's likelihood
+func observePrediction(prediction RainbowPrediction) {
+	predictionLikelihood.WithLabelValues(prediction.Basis).Observe(prediction.Likelihood)
+	if prediction.Likelihood == 0 {
+		zeroLikelihoodPredictions.WithLabelValues(prediction.Basis).Inc()
+	}
+}
+
+// statusRecorder captures the status code and body size written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// Write counts the body bytes before passing them on
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// WriteHeader records the status code before passing it on
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hijack hands the connection to a WebSocket upgrade, which answers with 101 itself
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// instrument records request counts and durations for every matched route
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+
+		httpRequestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gorilla/mux"
+)
+
+// requestIDHeader carries the request correlation ID between clients and the server
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength caps client-supplied request IDs so they can't bloat the logs
+const maxRequestIDLength = 128
+
+// contextKey namespaces values this package stores in request contexts
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	geocodeCacheBypassKey
+)
+
+// trackInFlight counts requests that are currently being served so shutdown can report what it is draining
+func (s *Server) trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withRequestID tags each request with an ID, reusing a well-formed X-Request-ID from the
+// client or generating one. The ID is echoed in the response and attached to a
+// request-scoped logger that handlers fetch with log.FromContext.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		ctx = log.WithContext(ctx, log.With("request_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// accessLog logs the method, path, status, response size and duration of every request
+// through the request-scoped logger, so each line carries the request ID. Lines are logged
+// at info level, or warn for server errors, and follow the configured log level.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+
+		logFn := log.FromContext(r.Context()).Info
+		if rec.status >= http.StatusInternalServerError {
+			logFn = log.FromContext(r.Context()).Warn
+		}
+		logFn("Request served",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+		)
+	})
+}
+
+// limitRequestBody caps request bodies at maxBytes. A body declared bigger is refused with
+// 413 straight away; one that only turns out bigger fails the handler's read with an
+// *http.MaxBytesError, which decodeJSONBody reports as 413 too.
+func limitRequestBody(maxBytes int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > int64(maxBytes) {
+				writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body too large; the limit is %d bytes", maxBytes))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// decodeJSONBody decodes the request body into v. On failure it also returns the status to
+// answer with: 413 when the body exceeded the limit set by limitRequestBody, 400 otherwise.
+func decodeJSONBody(r *http.Request, v any) (int, error) {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil {
+		return http.StatusOK, nil
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("body exceeds the limit of %d bytes", tooLarge.Limit)
+	}
+	return http.StatusBadRequest, err
+}
+
+// methodNotAllowed answers a request whose path matches a route but not its method with a
+// 405 JSON error, listing the methods the path does accept in the Allow header
+func methodNotAllowed(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			var match mux.RouteMatch
+			if router.Match(probe, &match) && match.MatchErr == nil {
+				allowed = append(allowed, method)
+			}
+		}
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+		}
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	})
+}
+
+// recoverPanics turns a panicking handler into a 500 JSON error so one bad request can't
+// take the server down. The stack trace is logged with the request ID for correlation.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				// Deliberate aborts should still drop the connection
+				panic(p)
+			}
+			// The request-scoped logger already carries the request ID
+			log.FromContext(r.Context()).Error("Recovered from panic in handler", "panic", p, "stack", string(debug.Stack()))
+			writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestIDFromContext returns the request ID stored by withRequestID, or "" if there is none
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// validRequestID reports whether a client-supplied ID is safe to log and echo back
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		isAlnum := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+		if !isAlnum && c != '-' && c != '_' && c != '.' && c != ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random version 4 UUID
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
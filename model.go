@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// LikelihoodModel scores how likely a rainbow is under the given conditions. Keeping the
+// model behind an interface lets alternative scoring approaches be compared side by side.
+type LikelihoodModel interface {
+	Score(ctx context.Context, conditions Conditions) (float64, Breakdown)
+}
+
+// Breakdown explains a model's score
+type Breakdown struct {
+	// Kind is bowTypeRainbow or bowTypeMoonbow, or empty when no bow is possible
+	Kind    string
+	Factors LikelihoodFactors
+}
+
+// HeuristicModel is the weighted-factor model tuned by LikelihoodWeights
+type HeuristicModel struct {
+	Weights LikelihoodWeights
+}
+
+// Score implements LikelihoodModel using calculateRainbowLikelihood
+func (m HeuristicModel) Score(ctx context.Context, conditions Conditions) (float64, Breakdown) {
+	likelihood, kind, factors := calculateRainbowLikelihood(ctx, conditions, m.Weights)
+	return likelihood, Breakdown{Kind: kind, Factors: factors}
+}
+
+// defaultLikelihoodModel is the model used when none is configured
+const defaultLikelihoodModel = "heuristic"
+
+// likelihoodModels maps the names accepted by LIKELIHOOD_MODEL to their constructors
+var likelihoodModels = map[string]func(cfg Config) LikelihoodModel{
+	defaultLikelihoodModel: func(cfg Config) LikelihoodModel { return HeuristicModel{Weights: cfg.Weights} },
+}
+
+// newLikelihoodModel creates the model named by cfg.LikelihoodModel
+func newLikelihoodModel(cfg Config) (LikelihoodModel, error) {
+	newModel, ok := likelihoodModels[cfg.LikelihoodModel]
+	if !ok {
+		names := make([]string, 0, len(likelihoodModels))
+		for name := range likelihoodModels {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return nil, fmt.Errorf("unknown likelihood model %q; must be one of %s", cfg.LikelihoodModel, strings.Join(names, ", "))
+	}
+	return newModel(cfg), nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// synodicMonth is the mean length of a lunar phase cycle in days
+	synodicMonth = 29.530588853
+	// minMoonbowIllumination is the illuminated fraction below which moonlight is too faint for a moonbow
+	minMoonbowIllumination = 0.8
+	// moonbowBrightness discounts moonbows relative to rainbows since they are far fainter
+	moonbowBrightness = 0.3
+)
+
+// referenceNewMoon is a known new moon (2000-01-06 18:14 UTC) used to date lunar phases
+var referenceNewMoon = time.Date(2000, time.January, 6, 18, 14, 0, 0, time.UTC)
+
+// moonPhase returns the fraction of the lunar cycle elapsed at t: 0 is new moon, 0.5 is full moon
+func moonPhase(t time.Time) float64 {
+	days := t.Sub(referenceNewMoon).Hours() / 24
+	phase := math.Mod(days/synodicMonth, 1)
+	if phase < 0 {
+		phase++
+	}
+	return phase
+}
+
+// moonIllumination returns the illuminated fraction of the moon's disc at t, from 0 to 1
+func moonIllumination(t time.Time) float64 {
+	return (1 - math.Cos(2*math.Pi*moonPhase(t))) / 2
+}
+
+// lunarPosition returns the moon's altitude and azimuth in degrees for an observer at
+// lat/lon at time t. It uses a low-precision ephemeris good to about a degree, which is
+// plenty for deciding whether a moonbow is possible. Azimuth is clockwise from true north.
+func lunarPosition(lat, lon float64, t time.Time) (altitude, azimuth float64) {
+	d := float64(t.Unix())/86400 + 2440587.5 - 2451545 // days since J2000
+
+	meanLong := 218.316 + 13.176396*d
+	meanAnom := 134.963 + 13.064993*d
+	meanDist := 93.272 + 13.229350*d
+
+	eclLong := meanLong + 6.289*sinDeg(meanAnom)
+	eclLat := 5.128 * sinDeg(meanDist)
+	const obliquity = 23.4397
+
+	rightAsc := radToDeg(math.Atan2(sinDeg(eclLong)*cosDeg(obliquity)-math.Tan(degToRad(eclLat))*sinDeg(obliquity), cosDeg(eclLong)))
+	declination := asinDeg(sinDeg(eclLat)*cosDeg(obliquity) + cosDeg(eclLat)*sinDeg(obliquity)*sinDeg(eclLong))
+
+	siderealTime := 280.16 + 360.9856235*d + lon
+	hourAngle := siderealTime - rightAsc
+
+	altitude = asinDeg(sinDeg(lat)*sinDeg(declination) + cosDeg(lat)*cosDeg(declination)*cosDeg(hourAngle))
+	// Azimuth from south, converted to a bearing from north
+	fromSouth := radToDeg(math.Atan2(sinDeg(hourAngle), cosDeg(hourAngle)*sinDeg(lat)-math.Tan(degToRad(declination))*cosDeg(lat)))
+	azimuth = math.Mod(fromSouth+180+360, 360)
+	return altitude, azimuth
+}
+
+// antilunarBearing returns the bearing directly opposite the moon, where a moonbow's center lies
+func antilunarBearing(lat, lon float64, t time.Time) float64 {
+	_, azimuth := lunarPosition(lat, lon, t)
+	return math.Mod(azimuth+180, 360)
+}
+
+// moonbowFactor scales likelihood for a moonbow at night. Like a rainbow it needs the moon
+// above the horizon but below maxRainbowSolarAltitude, and it needs a nearly full moon.
+func moonbowFactor(lat, lon float64, t time.Time) float64 {
+	illumination := moonIllumination(t)
+	if illumination < minMoonbowIllumination {
+		return 0
+	}
+	altitude, _ := lunarPosition(lat, lon, t)
+	return solarFactor(altitude) * illumination * moonbowBrightness
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// mediaTypeJSON is the media type of every JSON response
+const mediaTypeJSON = "application/json"
+
+// errNotAcceptable is returned when the Accept header allows none of the media types an
+// endpoint can produce; handlers answer it with 406
+var errNotAcceptable = errors.New("not acceptable")
+
+// negotiateMediaType picks the media type from offered that the client's Accept header
+// rates highest, preferring earlier offers on ties. Each offer is rated by the most
+// specific range that matches it, so "text/csv;q=0, */*" rules out only CSV. Without an
+// Accept header the first offer is chosen.
+func negotiateMediaType(r *http.Request, offered []string) (string, error) {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return offered[0], nil
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offered {
+		q, specificity := 0.0, -1
+		for _, part := range strings.Split(strings.Join(accept, ","), ",") {
+			mediaRange, rangeQ, ok := parseMediaRange(part)
+			if !ok {
+				continue
+			}
+			if s := mediaRangeMatch(mediaRange, offer); s > specificity {
+				q, specificity = rangeQ, s
+			}
+		}
+		// A quality of zero means "not acceptable"
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("%w: supported media types are %s", errNotAcceptable, strings.Join(offered, ", "))
+	}
+	return best, nil
+}
+
+// parseMediaRange splits one Accept entry into its lowercased media range and quality
+func parseMediaRange(part string) (mediaRange string, q float64, ok bool) {
+	params := strings.Split(part, ";")
+	mediaRange = strings.ToLower(strings.TrimSpace(params[0]))
+	if mediaRange == "" {
+		return "", 0, false
+	}
+	q = 1.0
+	for _, param := range params[1:] {
+		if name, value, found := strings.Cut(param, "="); found && strings.TrimSpace(name) == "q" {
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+				return "", 0, false
+			}
+		}
+	}
+	return mediaRange, q, true
+}
+
+// mediaRangeMatch reports how specifically mediaRange matches mediaType: 2 for an exact
+// match, 1 for a type/* wildcard, 0 for */* and -1 for no match
+func mediaRangeMatch(mediaRange, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	default:
+		return -1
+	}
+}
+
+// acceptsJSON answers 406 and returns false when the client's Accept header rules out JSON,
+// the only format the endpoint produces
+func acceptsJSON(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Add("Vary", "Accept")
+	if _, err := negotiateMediaType(r, []string{mediaTypeJSON}); err != nil {
+		writeJSONError(w, http.StatusNotAcceptable, err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// openAPISchemas lists the response types published as OpenAPI components. Their schemas
+// are derived from the Go structs so the document can't drift from what handlers encode.
+var openAPISchemas = []any{
+	RainbowPrediction{},
+	HeatmapData{},
+	GeoJSONFeatureCollection{},
+	DailyForecast{},
+	BatchLocation{},
+	BatchPredictionResult{},
+	CompareLocation{},
+	ComparedLocation{},
+	DryRunResponse{},
+	ConditionsResponse{},
+	CacheDebugResponse{},
+	HeatmapProgress{},
+	ErrorResponse{},
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  map[string]any
+)
+
+// handleOpenAPI serves the OpenAPI 3 description of the API
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() { openAPIDoc = buildOpenAPI() })
+	writeJSON(w, http.StatusOK, openAPIDoc)
+}
+
+// buildOpenAPI assembles the OpenAPI document
+func buildOpenAPI() map[string]any {
+	schemas := map[string]any{}
+	for _, v := range openAPISchemas {
+		t := reflect.TypeOf(v)
+		schemas[t.Name()] = jsonSchema(t)
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Rainbows API",
+			"description": "Predicts where and when rainbows are likely to appear.",
+			"version":     "1.0.0",
+		},
+		"paths": map[string]any{
+			"/predict/{lat}/{lon}": map[string]any{
+				"get": predictOperation("Best rainbow hour for a coordinate",
+					pathParam("lat", "Latitude in degrees, -90 to 90"),
+					pathParam("lon", "Longitude in degrees, -180 to 180"),
+				),
+			},
+			"/predict": map[string]any{
+				"get": predictOperation("Best rainbow hour for a coordinate given as query parameters",
+					requiredQueryParam("lat", "number", "Latitude in degrees, -90 to 90"),
+					requiredQueryParam("lon", "number", "Longitude in degrees, -180 to 180"),
+				),
+			},
+			"/predict/now/{lat}/{lon}": map[string]any{
+				"get": predictOperation("Rainbow likelihood from the current conditions only",
+					pathParam("lat", "Latitude in degrees, -90 to 90"),
+					pathParam("lon", "Longitude in degrees, -180 to 180"),
+				),
+			},
+			"/predict/city/{name}": map[string]any{
+				"get": operation("Best rainbow hour for a named city", "RainbowPrediction", predictParams(
+					map[string]any{"name": "name", "in": "path", "required": true, "schema": map[string]any{"type": "string"}},
+					queryParam("nocache", "boolean", "Resolve the name upstream instead of using the geocoding cache"),
+				)...),
+			},
+			"/predict/zip/{code}": map[string]any{
+				"get": operation("Best rainbow hour for a postal code", "RainbowPrediction", predictParams(
+					map[string]any{"name": "code", "in": "path", "required": true, "schema": map[string]any{"type": "string"}},
+					queryParam("country", "string", "ISO 3166 country code of the postal code; US when omitted"),
+					queryParam("nocache", "boolean", "Resolve the code upstream instead of using the geocoding cache"),
+				)...),
+			},
+			"/predict/batch": map[string]any{
+				"post": batchOperation(),
+			},
+			"/compare": map[string]any{
+				"post": compareOperation(),
+			},
+			"/ws/predict/{lat}/{lon}": map[string]any{
+				"get": streamOperation(),
+			},
+			"/forecast/daily/{lat}/{lon}": map[string]any{
+				"get": operation("Best rainbow likelihood for each of the next seven days", "DailyForecast",
+					pathParam("lat", "Latitude in degrees, -90 to 90"),
+					pathParam("lon", "Longitude in degrees, -180 to 180"),
+					unitsParam(),
+				),
+			},
+			"/heatmap": map[string]any{
+				"get": heatmapOperation(),
+			},
+			"/debug/cache": map[string]any{
+				"get": operation("How the weather cache groups coordinates, and the key a prediction at lat and lon would use", "CacheDebugResponse",
+					queryParam("lat", "number", "Latitude in degrees, -90 to 90; with lon, shows the cache key for the point"),
+					queryParam("lon", "number", "Longitude in degrees, -180 to 180"),
+					unitsParam(),
+				),
+			},
+			"/conditions": map[string]any{
+				"get": operation("Weather condition ID ranges and the likelihood adjustment each maps to", "ConditionsResponse"),
+			},
+		},
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{
+					"type": "http", "scheme": "bearer",
+					"description": "Required on the prediction, compare, forecast and heatmap routes when the server is configured with API_TOKENS",
+				},
+			},
+		},
+	}
+}
+
+// operation describes a GET endpoint returning the named schema
+func operation(summary, schema string, params ...map[string]any) map[string]any {
+	return map[string]any{
+		"summary":    summary,
+		"parameters": params,
+		"responses": map[string]any{
+			"200":     jsonResponse("Success", schemaRef(schema)),
+			"default": jsonResponse("Error", schemaRef("ErrorResponse")),
+		},
+	}
+}
+
+// predictOperation describes a coordinate prediction endpoint, which answers a dry run
+// with the upstream request instead of a prediction
+func predictOperation(summary string, coordinates ...map[string]any) map[string]any {
+	params := predictParams(append(coordinates,
+		queryParam("dryrun", "boolean", "Return the upstream request URL, with the API key redacted, instead of fetching"),
+	)...)
+	op := operation(summary, "", params...)
+	op["responses"].(map[string]any)["200"] = jsonResponse("Success, or the upstream request when dryrun is set",
+		map[string]any{"oneOf": []any{schemaRef("RainbowPrediction"), schemaRef("DryRunResponse")}})
+	return op
+}
+
+// heatmapOperation describes GET /heatmap, which returns a different body per format
+func heatmapOperation() map[string]any {
+	op := operation("Rainbow likelihood over a grid around a point or across a bounding box", "",
+		queryParam("lat", "number", "Center latitude in degrees, -90 to 90; required unless bbox is given"),
+		queryParam("lon", "number", "Center longitude in degrees, -180 to 180; required unless bbox is given"),
+		queryParam("radius", "number", "Scan radius in miles; required unless bbox is given"),
+		queryParam("shape", "string", "circle (default) keeps grid points within radius; square keeps the whole grid radius miles either side of the center, about 27% more cells, so it reaches the cell cap sooner. Not allowed with bbox"),
+		queryParam("bbox", "string", "Area to scan as minLon,minLat,maxLon,maxLat, instead of lat, lon and radius"),
+		queryParam("resolution", "number", "Grid spacing in degrees; when omitted, the configured default, adjusted to fit the area within the cell cap"),
+		unitsParam(),
+		queryParam("includeErrors", "boolean", "Include cells that could not be scored, with a null likelihood and an error"),
+		map[string]any{"name": "format", "in": "query", "description": "Response format; without it the format is negotiated from the Accept header, answering 406 when none is acceptable", "schema": map[string]any{"type": "string", "enum": []string{heatmapFormatJSON, heatmapFormatGeoJSON, heatmapFormatCSV, heatmapFormatSSE}}},
+	)
+	op["responses"].(map[string]any)["200"] = map[string]any{
+		"description": "Success",
+		"content": map[string]any{
+			"application/json":     map[string]any{"schema": map[string]any{"type": "array", "items": schemaRef("HeatmapData")}},
+			"application/geo+json": map[string]any{"schema": schemaRef("GeoJSONFeatureCollection")},
+			"text/csv":             map[string]any{"schema": map[string]any{"type": "string", "description": "lat,lon,likelihood rows after a header row"}},
+			"text/event-stream": map[string]any{"schema": map[string]any{"type": "string",
+				"description": "cell events carrying a HeatmapData as each cell is scored, progress events carrying a HeatmapProgress every second, and a final done event carrying a HeatmapProgress"}},
+		},
+	}
+	return op
+}
+
+// batchOperation describes POST /predict/batch
+func batchOperation() map[string]any {
+	op := operation("Predictions for many coordinates at once", "", predictParams()...)
+	op["description"] = "Results are in request order and are streamed a chunk at a time as the locations are predicted. " +
+		"Batches larger than the configured MAX_BATCH_SIZE are refused with 413."
+	op["requestBody"] = map[string]any{
+		"required": true,
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": map[string]any{"type": "array", "items": schemaRef("BatchLocation")}},
+		},
+	}
+	op["responses"].(map[string]any)["200"] = jsonResponse("Results in request order",
+		map[string]any{"type": "array", "items": schemaRef("BatchPredictionResult")})
+	return op
+}
+
+// compareOperation describes POST /compare
+func compareOperation() map[string]any {
+	op := operation("Named locations ranked by rainbow likelihood", "", predictParams()...)
+	op["description"] = "Locations are predicted concurrently and returned highest likelihood first, ranked from 1; ties keep request order. " +
+		"Locations that could not be predicted have an error and no rank, and come last."
+	op["requestBody"] = map[string]any{
+		"required": true,
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": map[string]any{"type": "array", "items": schemaRef("CompareLocation")}},
+		},
+	}
+	op["responses"].(map[string]any)["200"] = jsonResponse("Locations by descending likelihood",
+		map[string]any{"type": "array", "items": schemaRef("ComparedLocation")})
+	return op
+}
+
+// streamOperation describes the WebSocket upgrade for live prediction updates
+func streamOperation() map[string]any {
+	op := operation("Stream RainbowPrediction messages over a WebSocket", "", predictParams(
+		pathParam("lat", "Latitude in degrees, -90 to 90"),
+		pathParam("lon", "Longitude in degrees, -180 to 180"),
+	)...)
+	op["description"] = "Each message is a RainbowPrediction, or an ErrorResponse if the weather could not be fetched."
+	responses := op["responses"].(map[string]any)
+	delete(responses, "200")
+	responses["101"] = map[string]any{"description": "Switching to the WebSocket protocol"}
+	return op
+}
+
+// jsonResponse describes an application/json response with the given schema
+func jsonResponse(description string, schema map[string]any) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content":     map[string]any{"application/json": map[string]any{"schema": schema}},
+	}
+}
+
+// schemaRef refers to a component schema by name
+func schemaRef(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// pathParam describes a required numeric path parameter
+func pathParam(name, description string) map[string]any {
+	return map[string]any{"name": name, "in": "path", "required": true, "description": description, "schema": map[string]any{"type": "number"}}
+}
+
+// queryParam describes an optional query parameter of the given JSON type
+func queryParam(name, typ, description string) map[string]any {
+	return map[string]any{"name": name, "in": "query", "description": description, "schema": map[string]any{"type": typ}}
+}
+
+// requiredQueryParam describes a query parameter that must be present
+func requiredQueryParam(name, typ, description string) map[string]any {
+	param := queryParam(name, typ, description)
+	param["required"] = true
+	return param
+}
+
+// predictParams appends the options shared by every prediction endpoint to params
+func predictParams(params ...map[string]any) []map[string]any {
+	return append(params,
+		unitsParam(),
+		queryParam("timeline", "boolean", "Include the likelihood for every forecast hour"),
+		queryParam("explain", "boolean", "Include the factors behind the likelihood"),
+		queryParam("threshold", "number", "Likelihood from 0 to 1 below which no rainbow is expected"),
+		queryParam("interpolate", "boolean", "Estimate a sub-hour peak time from the neighboring hours"),
+		queryParam("includeWeather", "boolean", "Include the raw weather of the predicted hour: temperature, humidity, clouds, UV index, visibility and wind"),
+	)
+}
+
+// unitsParam describes the units query parameter shared by most endpoints
+func unitsParam() map[string]any {
+	return map[string]any{
+		"name": "units", "in": "query", "description": "Unit system for the upstream weather data",
+		"schema": map[string]any{"type": "string", "enum": []string{unitsMetric, unitsImperial}, "default": unitsMetric},
+	}
+}
+
+// jsonSchema derives a JSON schema for t from its encoding/json behavior. Fields without
+// omitempty are listed as required. A field tagged nullable:"true" is marked nullable for
+// types whose MarshalJSON encodes a non-pointer field as null.
+func jsonSchema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := jsonSchema(t.Elem())
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]any{}
+		var required []string
+		for i := range t.NumField() {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			property := jsonSchema(field.Type)
+			if field.Tag.Get("nullable") == "true" {
+				property["nullable"] = true
+			}
+			properties[name] = property
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]any{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	default:
+		return map[string]any{}
+	}
+}
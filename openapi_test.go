@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// openAPIResponseSchema returns the documented 200 schema of the GET operation at path for
+// the media type, with the document round-tripped through JSON as a client would see it
+func openAPIResponseSchema(t *testing.T, path, mediaType string) (schema map[string]any, components map[string]any) {
+	t.Helper()
+	raw, err := json.Marshal(buildOpenAPI())
+	if err != nil {
+		t.Fatalf("encoding OpenAPI document: %v", err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatalf("decoding OpenAPI document: %v", err)
+	}
+	components = doc["components"].(map[string]any)["schemas"].(map[string]any)
+	op, ok := doc["paths"].(map[string]any)[path].(map[string]any)["get"].(map[string]any)
+	if !ok {
+		t.Fatalf("no GET operation documented for %s", path)
+	}
+	content := op["responses"].(map[string]any)["200"].(map[string]any)["content"].(map[string]any)
+	media, ok := content[mediaType].(map[string]any)
+	if !ok {
+		t.Fatalf("no %s response documented for %s", mediaType, path)
+	}
+	return media["schema"].(map[string]any), components
+}
+
+// validateSchema checks a decoded JSON value against the subset of JSON Schema that
+// jsonSchema and the operation builders produce. Properties missing from an object schema
+// are reported too, so fields added to a response type without reaching the document show up.
+func validateSchema(value any, schema, components map[string]any, at string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		resolved, ok := components[name].(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: unknown schema %s", at, ref)}
+		}
+		return validateSchema(value, resolved, components, at)
+	}
+	if oneOf, ok := schema["oneOf"].([]any); ok {
+		matches := 0
+		var problems []string
+		for _, option := range oneOf {
+			optionProblems := validateSchema(value, option.(map[string]any), components, at)
+			if len(optionProblems) == 0 {
+				matches++
+			}
+			problems = append(problems, optionProblems...)
+		}
+		if matches != 1 {
+			return append([]string{fmt.Sprintf("%s: matches %d of the oneOf schemas, want 1", at, matches)}, problems...)
+		}
+		return nil
+	}
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s: null but not nullable", at)}
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: %T, want object", at, value)}
+		}
+		var problems []string
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing required property %q", at, name))
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		additional, _ := schema["additionalProperties"].(map[string]any)
+		for name, v := range obj {
+			propertySchema, ok := properties[name].(map[string]any)
+			if !ok {
+				propertySchema = additional
+			}
+			if propertySchema == nil {
+				problems = append(problems, fmt.Sprintf("%s: undocumented property %q", at, name))
+				continue
+			}
+			problems = append(problems, validateSchema(v, propertySchema, components, at+"."+name)...)
+		}
+		return problems
+	case "array":
+		arr, ok := value.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: %T, want array", at, value)}
+		}
+		items, _ := schema["items"].(map[string]any)
+		var problems []string
+		for i, item := range arr {
+			problems = append(problems, validateSchema(item, items, components, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+		return problems
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: %T, want string", at, value)}
+		}
+		if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, any(s)) {
+			return []string{fmt.Sprintf("%s: %q is not one of %v", at, s, enum)}
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return []string{fmt.Sprintf("%s: %T, want number", at, value)}
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			return []string{fmt.Sprintf("%s: %v, want integer", at, value)}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s: %T, want boolean", at, value)}
+		}
+	}
+	return nil
+}
+
+// failingProvider fails every fetch with err
+type failingProvider struct{ err error }
+
+func (p failingProvider) CurrentAndHourly(ctx context.Context, lat, lon float64, opts FetchOptions) (WeatherData, error) {
+	return WeatherData{}, p.err
+}
+
+func TestResponsesMatchOpenAPISchemas(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		mediaType string
+		provider  func(t *testing.T) WeatherProvider
+		handler   func(s *Server) http.HandlerFunc
+		target    string
+	}{
+		{
+			name: "prediction", path: "/predict", mediaType: mediaTypeJSON,
+			handler: func(s *Server) http.HandlerFunc { return s.handlePrediction },
+			target:  "/predict?lat=51.5&lon=-0.12",
+		},
+		{
+			name: "detailed prediction", path: "/predict", mediaType: mediaTypeJSON,
+			handler: func(s *Server) http.HandlerFunc { return s.handlePrediction },
+			target:  "/predict?lat=51.5&lon=-0.12&timeline=true&explain=true&includeWeather=true&interpolate=true",
+		},
+		{
+			name: "prediction dry run", path: "/predict", mediaType: mediaTypeJSON,
+			provider: func(t *testing.T) WeatherProvider {
+				p, _ := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+					t.Error("dry run reached the upstream")
+				})
+				return p
+			},
+			handler: func(s *Server) http.HandlerFunc { return s.handlePrediction },
+			target:  "/predict?lat=51.5&lon=-0.12&dryrun=true",
+		},
+		{
+			name: "heatmap", path: "/heatmap", mediaType: mediaTypeJSON,
+			handler: func(s *Server) http.HandlerFunc { return s.handleHeatmapData },
+			target:  "/heatmap?lat=51.5&lon=-0.12&radius=10&resolution=0.1",
+		},
+		{
+			name: "heatmap with errors", path: "/heatmap", mediaType: mediaTypeJSON,
+			provider: func(t *testing.T) WeatherProvider {
+				return failingProvider{err: ErrUpstreamUnavailable}
+			},
+			handler: func(s *Server) http.HandlerFunc { return s.handleHeatmapData },
+			target:  "/heatmap?lat=51.5&lon=-0.12&radius=10&resolution=0.1&includeErrors=true",
+		},
+		{
+			name: "heatmap geojson", path: "/heatmap", mediaType: "application/geo+json",
+			handler: func(s *Server) http.HandlerFunc { return s.handleHeatmapData },
+			target:  "/heatmap?lat=51.5&lon=-0.12&radius=10&resolution=0.1&format=geojson",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var provider WeatherProvider = &countingProvider{data: testAfternoonWeather(t)}
+			if tt.provider != nil {
+				provider = tt.provider(t)
+			}
+			s := newTestServer(t, provider)
+			rec := serve(tt.handler(s), tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var body any
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			schema, components := openAPIResponseSchema(t, tt.path, tt.mediaType)
+			for _, problem := range validateSchema(body, schema, components, "body") {
+				t.Error(problem)
+			}
+		})
+	}
+}
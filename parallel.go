@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// forEachBounded calls fn for each index in [0, n), running at most limit calls at once,
+// and returns when every started call has finished. Once ctx is canceled no further calls
+// are started and ctx's error is returned; calls already running are left to observe ctx.
+func forEachBounded(ctx context.Context, n, limit int, fn func(i int)) error {
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for i := range n {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}()
+	}
+	return nil
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gorilla/mux"
+)
+
+// RainbowPrediction represents the prediction result for rainbow occurrence
+type RainbowPrediction struct {
+	Likelihood       float64            `json:"likelihood"`
+	Confidence       float64            `json:"confidence"`
+	Stale            bool               `json:"stale"`
+	DataAgeSeconds   int64              `json:"dataAgeSeconds"`
+	Source           string             `json:"source,omitempty"`
+	DataTime         string             `json:"dataTime,omitempty"`
+	Partial          bool               `json:"partial,omitempty"`
+	Likely           bool               `json:"likely"`
+	Message          string             `json:"message"`
+	Location         string             `json:"location"`
+	Time             string             `json:"time"`
+	Basis            string             `json:"basis"`
+	Units            string             `json:"units"`
+	Sunrise          string             `json:"sunrise,omitempty"`
+	Sunset           string             `json:"sunset,omitempty"`
+	Type             string             `json:"type,omitempty"`
+	Bow              string             `json:"bow"`
+	LookDirection    *LookDirection     `json:"lookDirection,omitempty"`
+	Wind             *Wind              `json:"wind,omitempty"`
+	Weather          *HourWeather       `json:"weather,omitempty"`
+	ResolvedLocation *GeoLocation       `json:"resolvedLocation,omitempty"`
+	Window           *RainbowWindow     `json:"window,omitempty"`
+	InterpolatedPeak string             `json:"interpolatedPeak,omitempty"`
+	Timeline         []TimelineEntry    `json:"timeline,omitempty"`
+	Factors          *LikelihoodFactors `json:"factors,omitempty"`
+}
+
+// etagSubject leaves out the data age and the confidence, which both shift with the clock
+// while the observation and forecast behind the prediction stay the same
+func (p RainbowPrediction) etagSubject() any {
+	p.DataAgeSeconds = 0
+	p.Confidence = 0
+	return p
+}
+
+// LookDirection is the compass bearing opposite the sun (or moon, for a moonbow) that an observer should face
+type LookDirection struct {
+	Bearing  float64 `json:"bearing"`
+	Cardinal string  `json:"cardinal"`
+}
+
+// Wind is the wind during the predicted hour, given for weather context only. The look
+// direction depends on the sun or moon, never on the wind.
+type Wind struct {
+	Degrees  int    `json:"degrees"`
+	Cardinal string `json:"cardinal"`
+}
+
+// HourWeather is the raw weather behind the predicted hour, included on request for display.
+// Temperature and wind speed are in the prediction's units.
+type HourWeather struct {
+	Temp       float64 `json:"temp"`
+	Humidity   int     `json:"humidity"`
+	Clouds     int     `json:"clouds"`
+	UVI        float64 `json:"uvi"`
+	Visibility int     `json:"visibility"`
+	WindSpeed  float64 `json:"windSpeed"`
+	WindDeg    int     `json:"windDeg"`
+	Pop        float64 `json:"pop"`
+	RainVolume float64 `json:"rainVolume,omitempty"`
+}
+
+// TimelineEntry is the rainbow likelihood for a single forecast hour
+type TimelineEntry struct {
+	Time       string  `json:"time"`
+	Likelihood float64 `json:"likelihood"`
+}
+
+// RainbowWindow is the next contiguous run of forecast hours at or above the likelihood
+// threshold. End is when the last hour in the run is over; Peak is its likeliest hour.
+type RainbowWindow struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+	Peak  string `json:"peak"`
+}
+
+// PredictOptions are the per-request settings that shape a prediction
+type PredictOptions struct {
+	// Units is the unit system for the upstream request, either unitsMetric or unitsImperial
+	Units string
+	// Timeline includes the likelihood for every forecast hour in the response
+	Timeline bool
+	// Explain includes the factors behind the chosen hour's likelihood in the response
+	Explain bool
+	// Threshold is the likelihood below which no rainbow is expected
+	Threshold float64
+	// Language is the language human-readable labels are written in
+	Language string
+	// Interpolate estimates a sub-hour peak time from the hours either side of the best one
+	Interpolate bool
+	// IncludeWeather includes the raw weather of the chosen hour in the response
+	IncludeWeather bool
+}
+
+// Prediction bases, saying what a prediction's time refers to. A "best" prediction's time is
+// the start of the likeliest forecast hour; a "now" prediction's time is when the current
+// conditions were observed. Either is noRainbowTime when the likelihood is zero.
+const (
+	predictionBasisBest = "best"
+	predictionBasisNow  = "now"
+)
+
+// noRainbowTime is reported as the time when no hour has a likelihood above zero
+const noRainbowTime = "none"
+
+// Messages summarizing whether a prediction clears the likelihood threshold
+const (
+	messageLikely   = "Rainbow possible"
+	messageUnlikely = "No rainbow expected"
+)
+
+// handlePrediction processes the prediction request and returns the best rainbow hour. The
+// coordinates come from the path in /predict/{lat}/{lon} or the query in /predict?lat=&lon=.
+func (s *Server) handlePrediction(w http.ResponseWriter, r *http.Request) {
+	s.serveCoordinatePrediction(w, r, hourlyExclude, s.predict)
+}
+
+// handleCurrentPrediction returns the rainbow prediction for the current conditions only
+func (s *Server) handleCurrentPrediction(w http.ResponseWriter, r *http.Request) {
+	s.serveCoordinatePrediction(w, r, currentExclude, s.predictNow)
+}
+
+// serveCoordinatePrediction parses the coordinates and options of a prediction request and
+// responds with the prediction made by predict. exclude is the blocks predict leaves out of
+// its upstream request, shown by a dry run.
+func (s *Server) serveCoordinatePrediction(w http.ResponseWriter, r *http.Request, exclude []string,
+	predict func(ctx context.Context, lat, lon float64, opts PredictOptions) (RainbowPrediction, error)) {
+	logger := log.FromContext(r.Context())
+	if !acceptsJSON(w, r) {
+		return
+	}
+	coords, err := coordinatesParam(r)
+	if err != nil {
+		logger.Error("Invalid coordinates", "error", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	lat, lon := coords.Lat, coords.Lon
+	opts, err := s.parsePredictOptions(r)
+	if err != nil {
+		logger.Error("Invalid prediction options", "error", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	dryRun, err := parseBoolParam(r, "dryrun")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if dryRun {
+		logger.Info("Handling prediction dry run", "latitude", lat, "longitude", lon, "options", opts)
+		s.writeDryRun(w, lat, lon, FetchOptions{Units: opts.Units, Exclude: exclude})
+		return
+	}
+
+	predictionRequests.Inc()
+	logger.Info("Handling prediction request", "latitude", lat, "longitude", lon, "options", opts)
+	setLanguageHeaders(w, opts.Language)
+
+	prediction, err := predict(r.Context(), lat, lon, opts)
+	if err != nil {
+		logger.Error("Error fetching weather data", "error", err)
+		writeUpstreamError(w, "Error fetching weather data", err)
+		return
+	}
+
+	// Send the prediction as JSON response, or 304 if the client already has it
+	writeConditionalJSON(w, r, prediction)
+}
+
+// handleCityPrediction resolves a city name to coordinates and returns the rainbow prediction there
+func (s *Server) handleCityPrediction(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	s.serveGeocodedPrediction(w, r, "city", name, func(ctx context.Context) (GeoLocation, error) {
+		return s.geocoder.Geocode(ctx, name)
+	})
+}
+
+// handleZipPrediction resolves a postal code, in the country given by the optional country
+// parameter, to coordinates and returns the rainbow prediction there
+func (s *Server) handleZipPrediction(w http.ResponseWriter, r *http.Request) {
+	code := mux.Vars(r)["code"]
+	country := r.URL.Query().Get("country")
+	s.serveGeocodedPrediction(w, r, "postal code", code, func(ctx context.Context) (GeoLocation, error) {
+		return s.geocoder.GeocodeZip(ctx, code, country)
+	})
+}
+
+// serveGeocodedPrediction resolves a place with geocode and writes the prediction for it.
+// kind and query name the place in logs and in the 404 answered when it is unknown.
+func (s *Server) serveGeocodedPrediction(w http.ResponseWriter, r *http.Request, kind, query string, geocode func(ctx context.Context) (GeoLocation, error)) {
+	logger := log.FromContext(r.Context())
+	if !acceptsJSON(w, r) {
+		return
+	}
+	opts, err := s.parsePredictOptions(r)
+	if err != nil {
+		logger.Error("Invalid prediction options", "error", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	bypassCache, err := parseBoolParam(r, "nocache")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	predictionRequests.Inc()
+	logger.Info("Handling "+kind+" prediction request", "query", query, "options", opts)
+	setLanguageHeaders(w, opts.Language)
+
+	geocodeCtx := r.Context()
+	if bypassCache {
+		geocodeCtx = withGeocodeCacheBypass(geocodeCtx)
+	}
+	location, err := geocode(geocodeCtx)
+	if errors.Is(err, ErrLocationNotFound) {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("No location found matching %q", query))
+		return
+	}
+	if err != nil {
+		logger.Error("Error geocoding "+kind, "query", query, "error", err)
+		writeUpstreamError(w, "Error geocoding "+kind, err)
+		return
+	}
+
+	prediction, err := s.predict(r.Context(), location.Lat, location.Lon, opts)
+	if err != nil {
+		logger.Error("Error fetching weather data", "error", err)
+		writeUpstreamError(w, "Error fetching weather data", err)
+		return
+	}
+	prediction.ResolvedLocation = &location
+
+	writeConditionalJSON(w, r, prediction)
+}
+
+// parseUnits reads the optional units query parameter, defaulting to metric
+func parseUnits(r *http.Request) (string, error) {
+	switch units := r.URL.Query().Get("units"); units {
+	case "":
+		return unitsMetric, nil
+	case unitsMetric, unitsImperial:
+		return units, nil
+	default:
+		return "", fmt.Errorf("invalid units %q; must be %q or %q", units, unitsMetric, unitsImperial)
+	}
+}
+
+// parsePredictOptions reads the optional query parameters accepted by the prediction endpoints,
+// falling back to the configured likelihood threshold, and the language from Accept-Language
+func (s *Server) parsePredictOptions(r *http.Request) (PredictOptions, error) {
+	opts := PredictOptions{Threshold: s.config.LikelihoodThreshold, Language: negotiateLanguage(r)}
+	var err error
+	if opts.Units, err = parseUnits(r); err != nil {
+		return PredictOptions{}, err
+	}
+	if opts.Timeline, err = parseBoolParam(r, "timeline"); err != nil {
+		return PredictOptions{}, err
+	}
+	if opts.Explain, err = parseBoolParam(r, "explain"); err != nil {
+		return PredictOptions{}, err
+	}
+	if opts.Interpolate, err = parseBoolParam(r, "interpolate"); err != nil {
+		return PredictOptions{}, err
+	}
+	if opts.IncludeWeather, err = parseBoolParam(r, "includeWeather"); err != nil {
+		return PredictOptions{}, err
+	}
+	if v := r.URL.Query().Get("threshold"); v != "" {
+		threshold, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(threshold >= 0 && threshold <= 1) {
+			return PredictOptions{}, fmt.Errorf("invalid threshold %q; must be a number between 0 and 1", v)
+		}
+		opts.Threshold = threshold
+	}
+	return opts, nil
+}
+
+// parseBoolParam reads an optional boolean query parameter, treating a missing value as false
+func parseBoolParam(r *http.Request, name string) (bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q; must be true or false", name, v)
+	}
+	return b, nil
+}
+
+// predict fetches the weather at lat/lon and finds the hour with the highest rainbow likelihood
+func (s *Server) predict(ctx context.Context, lat, lon float64, opts PredictOptions) (RainbowPrediction, error) {
+	logger := log.FromContext(ctx)
+	weatherData, err := s.provider.CurrentAndHourly(ctx, lat, lon, FetchOptions{Units: opts.Units, Exclude: hourlyExclude})
+	if err != nil {
+		return RainbowPrediction{}, err
+	}
+
+	var best *scoredHour
+	bestIndex := 0
+	var timeline []TimelineEntry
+	likelihoods := make([]float64, len(weatherData.Hourly))
+
+	// Find the time with the highest rainbow likelihood
+	for i, hourly := range weatherData.Hourly {
+		hour := s.scoreHour(ctx, hourly.conditions(lat, lon, opts.Units))
+		likelihoods[i] = hour.likelihood
+		if opts.Timeline {
+			timeline = append(timeline, TimelineEntry{
+				Time:       time.Unix(hourly.Dt, 0).Format(time.RFC3339),
+				Likelihood: hour.likelihood,
+			})
+		}
+
+		// The nearest hour is explained when no hour scores above zero
+		if best == nil || hour.likelihood > best.likelihood {
+			best, bestIndex = &hour, i
+		}
+	}
+
+	prediction := s.describePrediction(ctx, weatherData, lat, lon, opts, best)
+	prediction.Basis = predictionBasisBest
+	prediction.Window = rainbowWindow(weatherData.Hourly, likelihoods, opts.Threshold)
+	prediction.Timeline = timeline
+	if opts.Interpolate && prediction.Likelihood > 0 {
+		prediction.InterpolatedPeak = interpolatePeak(weatherData.Hourly, likelihoods, bestIndex).Format(time.RFC3339)
+	}
+
+	observePrediction(prediction)
+	logger.Info("Prediction calculated", "prediction", prediction)
+	return prediction, nil
+}
+
+// predictNow scores only the current conditions at lat/lon, ignoring the forecast
+func (s *Server) predictNow(ctx context.Context, lat, lon float64, opts PredictOptions) (RainbowPrediction, error) {
+	logger := log.FromContext(ctx)
+	weatherData, err := s.provider.CurrentAndHourly(ctx, lat, lon, FetchOptions{Units: opts.Units, Exclude: currentExclude})
+	if err != nil {
+		return RainbowPrediction{}, err
+	}
+
+	current := s.scoreHour(ctx, weatherData.Current.conditions(lat, lon, opts.Units))
+	prediction := s.describePrediction(ctx, weatherData, lat, lon, opts, &current)
+	prediction.Basis = predictionBasisNow
+
+	observePrediction(prediction)
+	logger.Info("Current prediction calculated", "prediction", prediction)
+	return prediction, nil
+}
+
+// scoredHour is one set of conditions along with the model's score for it
+type scoredHour struct {
+	likelihood float64
+	conditions Conditions
+	breakdown  Breakdown
+}
+
+// scoreHour runs the likelihood model over conditions
+func (s *Server) scoreHour(ctx context.Context, conditions Conditions) scoredHour {
+	likelihood, breakdown := s.model.Score(ctx, conditions)
+	return scoredHour{likelihood: likelihood, conditions: conditions, breakdown: breakdown}
+}
+
+// describePrediction builds the response for the chosen hour, which is nil when the
+// upstream returned no conditions to score
+func (s *Server) describePrediction(ctx context.Context, weatherData WeatherData, lat, lon float64, opts PredictOptions, hour *scoredHour) RainbowPrediction {
+	logger := log.FromContext(ctx)
+	prediction := RainbowPrediction{
+		Message:  translate(opts.Language, messageUnlikely),
+		Location: fmt.Sprintf("%.4f, %.4f", lat, lon),
+		Time:     noRainbowTime,
+		Units:    opts.Units,
+		Bow:      bowNone,
+		Source:   s.dataSource(),
+		Partial:  weatherData.Partial,
+	}
+	now := s.clock.Now()
+	var observed time.Time
+	if weatherData.Current.Dt != 0 {
+		observed = time.Unix(weatherData.Current.Dt, 0)
+		prediction.DataTime = observed.Format(time.RFC3339)
+		age := now.Sub(observed)
+		prediction.DataAgeSeconds = int64(math.Max(age.Seconds(), 0))
+		if age > s.config.MaxDataAge {
+			logger.Warn("Upstream weather data is stale", "age", age, "max_age", s.config.MaxDataAge)
+			prediction.Stale = true
+		}
+	}
+	// Sunrise and sunset are given in the location's own time zone
+	local := weatherData.location()
+	if weatherData.Current.Sunrise != 0 {
+		prediction.Sunrise = time.Unix(weatherData.Current.Sunrise, 0).In(local).Format(time.RFC3339)
+	}
+	if weatherData.Current.Sunset != 0 {
+		prediction.Sunset = time.Unix(weatherData.Current.Sunset, 0).In(local).Format(time.RFC3339)
+	}
+	// With no conditions at all there is nothing to be confident about or to describe
+	if hour == nil {
+		return prediction
+	}
+
+	prediction.Likelihood = hour.likelihood
+	prediction.Likely = hour.likelihood >= opts.Threshold && hour.likelihood > 0
+	prediction.Confidence = predictionConfidence(hour.conditions, observed, now, s.config.MaxDataAge)
+	prediction.Wind = &Wind{
+		Degrees:  hour.conditions.WindDeg,
+		Cardinal: translate(opts.Language, cardinalDirection(float64(hour.conditions.WindDeg))),
+	}
+	if prediction.Likely {
+		prediction.Message = translate(opts.Language, messageLikely)
+	}
+	prediction.Bow = classifyBow(prediction.Likely, hour.breakdown)
+	if opts.Explain {
+		prediction.Factors = &hour.breakdown.Factors
+	}
+	if opts.IncludeWeather {
+		prediction.Weather = &HourWeather{
+			Temp:       hour.conditions.Temp,
+			Humidity:   hour.conditions.Humidity,
+			Clouds:     hour.conditions.Clouds,
+			UVI:        hour.conditions.UVI,
+			Visibility: hour.conditions.Visibility,
+			WindSpeed:  hour.conditions.WindSpeed,
+			WindDeg:    hour.conditions.WindDeg,
+			Pop:        hour.conditions.Pop,
+			RainVolume: hour.conditions.RainVolume,
+		}
+	}
+	if hour.likelihood > 0 {
+		at := hour.conditions.Time
+		prediction.Time = at.Format(time.RFC3339)
+		prediction.Type = hour.breakdown.Kind
+		// Bows appear opposite the light source, so the bearing is derived from the sun or
+		// moon position alone; wind direction plays no part
+		bearing := antisolarBearing(lat, lon, at)
+		if hour.breakdown.Kind == bowTypeMoonbow {
+			bearing = antilunarBearing(lat, lon, at)
+		}
+		prediction.LookDirection = &LookDirection{
+			Bearing:  math.Round(bearing*10) / 10,
+			Cardinal: translate(opts.Language, cardinalDirection(bearing)),
+		}
+	}
+	return prediction
+}
+
+// interpolatePeak estimates when the likelihood actually peaks around the best hour. Lines
+// of equal and opposite slope are fitted through the best hour and its neighbors, and
+// their crossing, within half an hour either side, is taken as the peak. With a neighbor
+// missing, or no likelier side, the hour itself is returned.
+func interpolatePeak(hours []HourlyWeather, likelihoods []float64, peak int) time.Time {
+	at := time.Unix(hours[peak].Dt, 0)
+	if peak == 0 || peak == len(hours)-1 {
+		return at
+	}
+	before, best, after := likelihoods[peak-1], likelihoods[peak], likelihoods[peak+1]
+	var offset float64
+	switch {
+	case after > before && best > before:
+		offset = 0.5 * (after - before) / (best - before)
+	case before > after && best > after:
+		offset = 0.5 * (after - before) / (best - after)
+	default:
+		return at
+	}
+	// Step toward the likelier neighbor by the fraction of the gap to it
+	gap := hours[peak+1].Dt - hours[peak].Dt
+	if offset < 0 {
+		gap = hours[peak].Dt - hours[peak-1].Dt
+	}
+	return at.Add(time.Duration(offset * float64(gap) * float64(time.Second))).Truncate(time.Minute)
+}
+
+// rainbowWindow finds the first run of consecutive hours whose likelihood is above zero and
+// at least threshold, returning nil when no hour qualifies
+func rainbowWindow(hours []HourlyWeather, likelihoods []float64, threshold float64) *RainbowWindow {
+	start := -1
+	for i, likelihood := range likelihoods {
+		if likelihood > 0 && likelihood >= threshold {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+
+	end, peak := start, start
+	for end+1 < len(likelihoods) && likelihoods[end+1] > 0 && likelihoods[end+1] >= threshold {
+		end++
+		if likelihoods[end] > likelihoods[peak] {
+			peak = end
+		}
+	}
+	return &RainbowWindow{
+		Start: time.Unix(hours[start].Dt, 0).Format(time.RFC3339),
+		End:   time.Unix(hours[end].Dt, 0).Add(time.Hour).Format(time.RFC3339),
+		Peak:  time.Unix(hours[peak].Dt, 0).Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPredictionWithoutWeatherConditions(t *testing.T) {
+	data := testAfternoonWeather(t)
+	data.Current.Weather = []WeatherCondition{}
+	for i := range data.Hourly {
+		data.Hourly[i].Weather = nil
+	}
+	s := newTestServer(t, &countingProvider{data: data})
+
+	for target, handler := range map[string]http.HandlerFunc{
+		"/predict?lat=51.5&lon=-0.12&explain=true": s.handlePrediction,
+		"/predict/current?lat=51.5&lon=-0.12":      s.handleCurrentPrediction,
+	} {
+		rec := serve(handler, target)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200: %s", target, rec.Code, rec.Body)
+		}
+		var prediction RainbowPrediction
+		if err := json.Unmarshal(rec.Body.Bytes(), &prediction); err != nil {
+			t.Fatalf("%s: decoding response: %v", target, err)
+		}
+		if prediction.Likelihood != 0 || prediction.Likely || prediction.Time != noRainbowTime || prediction.Bow != bowNone {
+			t.Errorf("%s: got likelihood %v, likely %v, time %q and bow %q, want no rainbow",
+				target, prediction.Likelihood, prediction.Likely, prediction.Time, prediction.Bow)
+		}
+	}
+}
+
+func TestPredictionLooksAwayFromTheSun(t *testing.T) {
+	predictWithWind := func(windDeg int) RainbowPrediction {
+		t.Helper()
+		data := testAfternoonWeather(t)
+		for i := range data.Hourly {
+			data.Hourly[i].WindDeg = windDeg
+		}
+		s := newTestServer(t, &countingProvider{data: data})
+		rec := serve(s.handlePrediction, "/predict?lat=51.5&lon=-0.12")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var prediction RainbowPrediction
+		if err := json.Unmarshal(rec.Body.Bytes(), &prediction); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		if prediction.LookDirection == nil {
+			t.Fatalf("prediction at %s has no look direction", prediction.Time)
+		}
+		return prediction
+	}
+
+	prediction := predictWithWind(270)
+	at, err := time.Parse(time.RFC3339, prediction.Time)
+	if err != nil {
+		t.Fatalf("parsing prediction time: %v", err)
+	}
+	sun := solarAzimuth(51.5, -0.12, at)
+	if d := angleBetween(prediction.LookDirection.Bearing, sun); math.Abs(d-180) > 0.05 {
+		t.Errorf("look bearing %.1f is %.1f° from the sun at %.1f, want 180°", prediction.LookDirection.Bearing, d, sun)
+	}
+	if want := cardinalDirection(prediction.LookDirection.Bearing); prediction.LookDirection.Cardinal != want {
+		t.Errorf("cardinal = %q, want %q", prediction.LookDirection.Cardinal, want)
+	}
+
+	for _, windDeg := range []int{0, 90, 180} {
+		if got := predictWithWind(windDeg).LookDirection; *got != *prediction.LookDirection {
+			t.Errorf("wind from %d° moved the look direction to %+v from %+v", windDeg, *got, *prediction.LookDirection)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// defaultBaseURL is the endpoint for the OpenWeatherMap One Call API
+const defaultBaseURL = "https://api.openweathermap.org/data/3.0/onecall"
+
+// httpClient is the shared client for upstream API requests; the timeout keeps
+// a slow OpenWeatherMap response from hanging a handler indefinitely
+var httpClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+// defaultUserAgent names this server and its build on upstream requests
+func defaultUserAgent() string {
+	return "rainbows/" + version + " (+https://github.com/nooooaaaaah/rainbows)"
+}
+
+// userAgentTransport sets the User-Agent header on every request it sends
+type userAgentTransport struct {
+	userAgent string
+	next      http.RoundTripper
+}
+
+// RoundTrip sends req with the configured User-Agent
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
+
+// Unit systems accepted by the OpenWeatherMap API
+const (
+	unitsMetric   = "metric"
+	unitsImperial = "imperial"
+)
+
+// FetchOptions controls how weather data is requested from a provider
+type FetchOptions struct {
+	// Units is the unit system for temperatures and speeds, either unitsMetric or unitsImperial
+	Units string
+	// Exclude names the One Call blocks to leave out of the response; nil requests them all
+	Exclude []string
+}
+
+// One Call response blocks, as named by the exclude parameter
+const (
+	blockMinutely = "minutely"
+	blockHourly   = "hourly"
+	blockDaily    = "daily"
+	blockAlerts   = "alerts"
+)
+
+var (
+	// hourlyExclude keeps the current and hourly blocks an hourly prediction reads
+	hourlyExclude = []string{blockMinutely, blockDaily, blockAlerts}
+	// currentExclude keeps only the current block, the minimal payload for current-only scoring
+	currentExclude = []string{blockMinutely, blockHourly, blockDaily, blockAlerts}
+	// dailyExclude keeps the current and daily blocks the daily forecast reads
+	dailyExclude = []string{blockMinutely, blockHourly, blockAlerts}
+)
+
+// WeatherProvider supplies current and hourly weather for a coordinate
+type WeatherProvider interface {
+	CurrentAndHourly(ctx context.Context, lat, lon float64, opts FetchOptions) (WeatherData, error)
+}
+
+// OpenWeatherMapProvider fetches weather from the OpenWeatherMap One Call API. baseURL and
+// geocodeURL default to the real endpoints but can point at a mock, a compatible proxy or a
+// stand-in server such as an httptest.Server.
+type OpenWeatherMapProvider struct {
+	keys       *apiKeyPool
+	baseURL    string
+	geocodeURL string
+	// zipGeocodeURL is derived from geocodeURL
+	zipGeocodeURL string
+	client        *http.Client
+	maxAttempts   int
+	retryBackoff  time.Duration
+	// slots bounds the upstream requests in flight across every caller
+	slots chan struct{}
+	// breaker fails calls fast while the upstream is down; nil when disabled
+	breaker *circuitBreaker
+	// clock times key benching, rate limit waits and upstream latency
+	clock Clock
+}
+
+// NewOpenWeatherMapProvider creates a provider using the API keys, endpoints, retry and circuit
+// breaker settings from cfg
+func NewOpenWeatherMapProvider(cfg Config, clock Clock) *OpenWeatherMapProvider {
+	return &OpenWeatherMapProvider{
+		keys:          newAPIKeyPool(cfg.APIKeys),
+		baseURL:       cfg.UpstreamBaseURL,
+		geocodeURL:    cfg.GeocodeBaseURL,
+		zipGeocodeURL: zipGeocodeURL(cfg.GeocodeBaseURL),
+		client:        httpClient,
+		maxAttempts:   cfg.UpstreamMaxAttempts,
+		retryBackoff:  cfg.UpstreamRetryBackoff,
+		slots:         make(chan struct{}, cfg.UpstreamConcurrency),
+		breaker:       newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown, clock),
+		clock:         clock,
+	}
+}
+
+// CurrentAndHourly returns the current and hourly weather for the given coordinates
+func (p *OpenWeatherMapProvider) CurrentAndHourly(ctx context.Context, lat, lon float64, opts FetchOptions) (WeatherData, error) {
+	return p.fetchWeatherData(ctx, lat, lon, opts)
+}
+
+// fetchWeatherData retrieves weather data from the OpenWeatherMap API for given coordinates.
+// Network errors and 5xx responses are retried with exponential backoff; 4xx responses are not.
+func (p *OpenWeatherMapProvider) fetchWeatherData(ctx context.Context, lat, lon float64, opts FetchOptions) (WeatherData, error) {
+	logger := log.FromContext(ctx)
+	// The key is added per attempt so it never appears in the logged URL
+	url := p.requestURL(lat, lon, opts)
+	logger.Debug("Fetching weather data", "url", url)
+
+	var lastErr error
+	for attempt := 1; attempt <= p.maxAttempts; attempt++ {
+		if attempt > 1 {
+			delay := backoffDelay(p.retryBackoff, attempt-1)
+			logger.Warn("Retrying weather request", "attempt", attempt, "delay", delay, "error", lastErr)
+			if err := sleepContext(ctx, delay); err != nil {
+				return WeatherData{}, fmt.Errorf("retry aborted: %w", err)
+			}
+		}
+
+		// A slot is held per attempt, not across the backoff, so waiting retries don't starve others
+		weatherData, retryable, err := p.fetchWithSlot(ctx, url)
+		if err == nil {
+			logger.Debug("Weather data fetched successfully", "data", weatherData)
+			return weatherData, nil
+		}
+		lastErr = err
+		if !retryable || ctx.Err() != nil {
+			break
+		}
+	}
+	return WeatherData{}, lastErr
+}
+
+// requestURL builds the One Call request URL for a coordinate, without the API key
+func (p *OpenWeatherMapProvider) requestURL(lat, lon float64, opts FetchOptions) string {
+	units := opts.Units
+	if units == "" {
+		units = unitsMetric
+	}
+	reqURL := fmt.Sprintf("%s?lat=%f&lon=%f", p.baseURL, lat, lon)
+	if len(opts.Exclude) > 0 {
+		reqURL += "&exclude=" + strings.Join(opts.Exclude, ",")
+	}
+	return reqURL + "&units=" + units
+}
+
+// upstreamURL returns the One Call request URL with the API key redacted
+func (p *OpenWeatherMapProvider) upstreamURL(lat, lon float64, opts FetchOptions) (string, bool) {
+	return p.requestURL(lat, lon, opts) + "&appid=" + redactedAPIKey, true
+}
+
+// acquireSlot waits for room under the server-wide upstream concurrency limit. The caller
+// must call releaseSlot once its request is done.
+func (p *OpenWeatherMapProvider) acquireSlot(ctx context.Context) error {
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseSlot frees a slot taken by acquireSlot
+func (p *OpenWeatherMapProvider) releaseSlot() {
+	<-p.slots
+}
+
+// fetchWithSlot makes the request with fetchWithKeys once a slot is free, unless the
+// circuit breaker is open
+func (p *OpenWeatherMapProvider) fetchWithSlot(ctx context.Context, url string) (weatherData WeatherData, retryable bool, err error) {
+	if err := p.breaker.allow(); err != nil {
+		return WeatherData{}, false, err
+	}
+	defer func() { p.breaker.record(ctx, err) }()
+	if err := p.acquireSlot(ctx); err != nil {
+		return WeatherData{}, false, err
+	}
+	defer p.releaseSlot()
+	return p.fetchWithKeys(ctx, url)
+}
+
+// fetchWithKeys makes a One Call request, moving straight on to the next API key when one is
+// refused with 401 or 429 and benching the refused key. Each key is tried at most once.
+func (p *OpenWeatherMapProvider) fetchWithKeys(ctx context.Context, url string) (WeatherData, bool, error) {
+	logger := log.FromContext(ctx)
+	var weatherData WeatherData
+	var retryable bool
+	var err error
+	for range p.keys.size() {
+		index, key := p.keys.pick(p.clock.Now())
+		weatherData, retryable, err = p.fetchOnce(ctx, url+"&appid="+key)
+		reason, bench, refused := keyFailure(err)
+		if !refused {
+			break
+		}
+		logger.Warn("API key refused; benching it", "key_index", index, "reason", reason, "for", bench)
+		apiKeysBenched.WithLabelValues(reason).Inc()
+		p.keys.bench(index, p.clock.Now(), bench)
+		if p.keys.size() == 1 {
+			break
+		}
+	}
+	return weatherData, retryable, err
+}
+
+// fetchOnce makes a single One Call request and reports whether a failure is worth retrying
+func (p *OpenWeatherMapProvider) fetchOnce(ctx context.Context, url string) (WeatherData, bool, error) {
+	logger := log.FromContext(ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		logger.Error("Error creating request", "error", err)
+		return WeatherData{}, false, fmt.Errorf("error creating request: %w", err)
+	}
+	start := p.clock.Now()
+	resp, err := p.client.Do(req)
+	upstreamLatency.Observe(p.clock.Now().Sub(start).Seconds())
+	if err != nil {
+		err = redactAPIKey(err)
+		logger.Error("Error making request", "error", err)
+		upstreamErrors.WithLabelValues("network").Inc()
+		return WeatherData{}, true, fmt.Errorf("%w: error making request: %w", ErrUpstreamUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		rateLimited := rateLimitErrorFrom(resp, p.clock.Now())
+		logger.Error("API rate limit exceeded", "retry_after", rateLimited.RetryAfter)
+		upstreamErrors.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+		return WeatherData{}, false, rateLimited
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		logger.Error("API key rejected", "status_code", resp.StatusCode)
+		upstreamErrors.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+		return WeatherData{}, false, fmt.Errorf("%w: status code %d", ErrUnauthorized, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("API request failed", "status_code", resp.StatusCode)
+		upstreamErrors.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+		if resp.StatusCode >= 500 {
+			return WeatherData{}, true, fmt.Errorf("%w: API request failed with status code: %d", ErrUpstreamUnavailable, resp.StatusCode)
+		}
+		return WeatherData{}, false, fmt.Errorf("API request failed with status code: %d", resp.StatusCode)
+	}
+
+	var weatherData WeatherData
+	body, err := io.ReadAll(resp.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &weatherData)
+	}
+	if err != nil {
+		partial, usable := decodePartialWeatherData(body)
+		if !usable {
+			logger.Error("Error decoding response", "error", err)
+			upstreamErrors.WithLabelValues("decode").Inc()
+			return WeatherData{}, false, fmt.Errorf("%w: %w", ErrDecode, err)
+		}
+		logger.Warn("Using the usable part of a malformed response", "error", err, "partial", partial.Partial, "hourly", len(partial.Hourly), "daily", len(partial.Daily))
+		upstreamErrors.WithLabelValues("partial").Inc()
+		return partial, false, nil
+	}
+	return weatherData, false, nil
+}
+
+// backoffDelay returns a randomized delay for the given retry, doubling the base
+// each time and applying full jitter so concurrent retries spread out
+func backoffDelay(base time.Duration, retry int) time.Duration {
+	ceiling := base << (retry - 1)
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling) + 1
+}
+
+// sleepContext waits for d or until ctx is done, whichever comes first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testOneCallBody is a minimal One Call response with a current block and two hours
+const testOneCallBody = `{"timezone":"UTC","timezone_offset":0,
+"current":{"dt":1700000000,"sunrise":1699990000,"sunset":1700020000,"humidity":90,"clouds":30,"weather":[{"id":520}]},
+"hourly":[{"dt":1700000000,"humidity":90,"weather":[{"id":520}]},{"dt":1700003600,"humidity":80,"weather":[{"id":800}]}]}`
+
+// testNow is the instant the fake clock starts at in provider tests
+var testNow = time.Date(2023, 11, 14, 22, 0, 0, 0, time.UTC)
+
+// newTestProvider points a provider at an httptest.Server running handler. Retries and the
+// circuit breaker are off so each call makes exactly one request.
+func newTestProvider(t *testing.T, handler http.HandlerFunc) (*OpenWeatherMapProvider, *fakeClock) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	clock := newFakeClock(testNow)
+	cfg := Config{
+		APIKeys:             []string{"test-key"},
+		UpstreamBaseURL:     srv.URL + "/data/3.0/onecall",
+		GeocodeBaseURL:      srv.URL + "/geo/1.0/direct",
+		UpstreamMaxAttempts: 1,
+		UpstreamConcurrency: 1,
+	}
+	return NewOpenWeatherMapProvider(cfg, clock), clock
+}
+
+func TestOpenWeatherMapProviderResponses(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		header  map[string]string
+		body    string
+		wantErr error
+	}{
+		{name: "ok", status: http.StatusOK, body: testOneCallBody},
+		{name: "unauthorized", status: http.StatusUnauthorized, body: `{"cod":401}`, wantErr: ErrUnauthorized},
+		{name: "rate limited", status: http.StatusTooManyRequests, header: map[string]string{"Retry-After": "30"}, wantErr: ErrRateLimited},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: ErrUpstreamUnavailable},
+		{name: "malformed body", status: http.StatusOK, body: `<html>oops</html>`, wantErr: ErrDecode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+				if got := r.URL.Query().Get("appid"); got != "test-key" {
+					t.Errorf("appid = %q, want test-key", got)
+				}
+				for k, v := range tt.header {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+
+			data, err := p.CurrentAndHourly(context.Background(), 51.5, -0.12, FetchOptions{Units: unitsMetric})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if data.Current.Dt != 1700000000 || len(data.Hourly) != 2 {
+				t.Errorf("decoded current dt %d with %d hours, want 1700000000 with 2", data.Current.Dt, len(data.Hourly))
+			}
+			if data.Partial {
+				t.Error("complete response marked partial")
+			}
+		})
+	}
+}
+
+func TestOpenWeatherMapProviderRateLimitRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   time.Duration
+	}{
+		{name: "seconds", header: "Retry-After", value: "30", want: 30 * time.Second},
+		{name: "http date", header: "Retry-After", value: testNow.Add(2 * time.Minute).Format(http.TimeFormat), want: 2 * time.Minute},
+		{name: "reset time", header: "X-RateLimit-Reset", value: strconv.FormatInt(testNow.Add(90*time.Second).Unix(), 10), want: 90 * time.Second},
+		{name: "missing", want: defaultUpstreamRetryAfter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.header != "" {
+					w.Header().Set(tt.header, tt.value)
+				}
+				w.WriteHeader(http.StatusTooManyRequests)
+			})
+
+			_, err := p.CurrentAndHourly(context.Background(), 51.5, -0.12, FetchOptions{Units: unitsMetric})
+			var rateLimited *RateLimitError
+			if !errors.As(err, &rateLimited) {
+				t.Fatalf("error = %v, want a RateLimitError", err)
+			}
+			if rateLimited.RetryAfter != tt.want {
+				t.Errorf("RetryAfter = %s, want %s", rateLimited.RetryAfter, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenWeatherMapProviderBenchesRefusedKey(t *testing.T) {
+	var keys []string
+	p, clock := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("appid")
+		keys = append(keys, key)
+		if key == "bad-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(testOneCallBody))
+	})
+	p.keys = newAPIKeyPool([]string{"bad-key", "good-key"})
+	ctx := context.Background()
+	opts := FetchOptions{Units: unitsMetric}
+
+	// The refused key is benched and the good one used straight away
+	if _, err := p.CurrentAndHourly(ctx, 51.5, -0.12, opts); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	// While benched the refused key is skipped
+	if _, err := p.CurrentAndHourly(ctx, 51.5, -0.12, opts); err != nil {
+		t.Fatalf("second call: %v", err)
+	}
+	// Once the bench ends it is tried again
+	clock.Advance(unauthorizedBench + time.Second)
+	p.CurrentAndHourly(ctx, 51.5, -0.12, opts)
+
+	want := []string{"bad-key", "good-key", "good-key", "bad-key", "good-key"}
+	if len(keys) != len(want) {
+		t.Fatalf("keys used = %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("keys used = %v, want %v", keys, want)
+		}
+	}
+}
+
+func TestPredictionRequestsAndReadsTheHourlyBlock(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		current     bool
+		wantHourly  bool
+		wantExclude string
+	}{
+		{name: "best hour", target: "/predict?lat=51.5&lon=-0.12&timeline=true", wantHourly: true, wantExclude: "minutely,daily,alerts"},
+		{name: "current only", target: "/predict/current?lat=51.5&lon=-0.12", current: true, wantExclude: "minutely,hourly,daily,alerts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var exclude string
+			p, _ := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+				exclude = r.URL.Query().Get("exclude")
+				w.Write([]byte(testAfternoonBody))
+			})
+			s := newTestServer(t, p)
+			handler := s.handlePrediction
+			if tt.current {
+				handler = s.handleCurrentPrediction
+			}
+			rec := serve(handler, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+			if exclude != tt.wantExclude {
+				t.Errorf("exclude = %q, want %q", exclude, tt.wantExclude)
+			}
+			if hourly := slices.Contains(strings.Split(exclude, ","), blockHourly); hourly == tt.wantHourly {
+				t.Errorf("hourly excluded: %v, want %v", hourly, !tt.wantHourly)
+			}
+		})
+	}
+
+	// The hourly entries reach the prediction: each becomes a timeline hour and the best
+	// one is chosen from them rather than from the current block
+	p, _ := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testAfternoonBody))
+	})
+	data, err := p.CurrentAndHourly(context.Background(), 51.5, -0.12, FetchOptions{Units: unitsMetric, Exclude: hourlyExclude})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(data.Hourly) != 4 || data.Hourly[3].Dt != 1717268400 || data.Hourly[2].Rain == nil || data.Hourly[2].Pop != 0.5 {
+		t.Fatalf("hourly block decoded as %+v, want the four hours of testAfternoonBody", data.Hourly)
+	}
+	s := newTestServer(t, p)
+	prediction, err := s.predict(context.Background(), 51.5, -0.12, PredictOptions{Units: unitsMetric, Timeline: true, Threshold: 0.1})
+	if err != nil {
+		t.Fatalf("predict: %v", err)
+	}
+	if len(prediction.Timeline) != len(data.Hourly) {
+		t.Errorf("timeline has %d hours, want %d", len(prediction.Timeline), len(data.Hourly))
+	}
+	if want := time.Unix(data.Hourly[2].Dt, 0).Format(time.RFC3339); prediction.Time != want {
+		t.Errorf("best hour = %s, want the shower at %s", prediction.Time, want)
+	}
+}
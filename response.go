@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// ErrorResponse is the JSON body returned for failed requests
+type ErrorResponse struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+	// Upstream names the external service at fault when the error came from one
+	Upstream string `json:"upstream,omitempty"`
+}
+
+// writeJSON sends v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	writeBody(w, status, v)
+}
+
+// writeBody encodes v as JSON without touching Content-Type, for JSON-based media types like GeoJSON
+func writeBody(w http.ResponseWriter, status int, v any) {
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("Error encoding JSON response", "error", err)
+	}
+}
+
+// writeJSONError sends a JSON error body with the given status code
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, ErrorResponse{Error: msg, Status: status})
+}
+
+// etagSubject is implemented by responses with fields that drift on every request, such as
+// the age of the data. etagSubject returns the response without them, so its ETag only
+// changes when the data behind it does.
+type etagSubject interface {
+	etagSubject() any
+}
+
+// writeConditionalJSON sends v as a 200 JSON response tagged with an ETag derived from the
+// encoded body, or from its etagSubject when it has one. If the request's If-None-Match
+// already names that ETag, a bodiless 304 is sent instead so polling clients don't download
+// an unchanged prediction again.
+func writeConditionalJSON(w http.ResponseWriter, r *http.Request, v any) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		log.Error("Error encoding JSON response", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Error encoding response")
+		return
+	}
+	tagged := body.Bytes()
+	if subject, ok := v.(etagSubject); ok {
+		var err error
+		if tagged, err = json.Marshal(subject.etagSubject()); err != nil {
+			log.Error("Error encoding JSON response", "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "Error encoding response")
+			return
+		}
+	}
+	sum := sha256.Sum256(tagged)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(body.Bytes()); err != nil {
+		log.Error("Error writing JSON response", "error", err)
+	}
+}
+
+// etagMatches reports whether an If-None-Match header value names etag. Comparison is weak,
+// as RFC 9110 requires for If-None-Match, so a W/ prefix on either side is ignored.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteConditionalJSONIgnoresDataAge(t *testing.T) {
+	prediction := RainbowPrediction{Likelihood: 0.4, Confidence: 0.9, DataAgeSeconds: 60, DataTime: "2024-06-01T17:00:00Z"}
+
+	first := httptest.NewRecorder()
+	writeConditionalJSON(first, httptest.NewRequest(http.MethodGet, "/predict/1/2", nil), prediction)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("first response: status %d, ETag %q", first.Code, etag)
+	}
+
+	// A moment later the same observation is older and the confidence has slipped
+	prediction.DataAgeSeconds = 62
+	prediction.Confidence = 0.89
+	req := httptest.NewRequest(http.MethodGet, "/predict/1/2", nil)
+	req.Header.Set("If-None-Match", etag)
+	second := httptest.NewRecorder()
+	writeConditionalJSON(second, req, prediction)
+	if second.Code != http.StatusNotModified {
+		t.Errorf("repeat request status = %d, want 304", second.Code)
+	}
+
+	// A new observation changes the tag
+	prediction.DataTime = "2024-06-01T17:10:00Z"
+	third := httptest.NewRecorder()
+	writeConditionalJSON(third, req, prediction)
+	if third.Code != http.StatusOK || third.Header().Get("ETag") == etag {
+		t.Errorf("new observation: status %d, ETag %q, want 200 with a new ETag", third.Code, third.Header().Get("ETag"))
+	}
+}
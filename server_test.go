@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testAfternoonBody is a One Call response for a showery June afternoon in London: rain
+// clearing from the west as the sun gets low, then a clear evening
+const testAfternoonBody = `{"timezone":"Europe/London","timezone_offset":3600,
+"current":{"dt":1717257600,"sunrise":1717213500,"sunset":1717272900,"temp":16.2,"humidity":82,"clouds":55,"uvi":2.1,"visibility":9000,"wind_speed":4.1,"wind_deg":250,"weather":[{"id":500,"main":"Rain","description":"light rain"}],"rain":{"1h":0.4}},
+"hourly":[
+{"dt":1717257600,"temp":16.2,"humidity":82,"clouds":55,"uvi":2.1,"visibility":9000,"wind_speed":4.1,"wind_deg":250,"pop":0.8,"weather":[{"id":500,"main":"Rain","description":"light rain"}],"rain":{"1h":0.4}},
+{"dt":1717261200,"temp":15.8,"humidity":85,"clouds":40,"uvi":1.2,"visibility":10000,"wind_speed":3.6,"wind_deg":260,"pop":0.7,"weather":[{"id":500,"main":"Rain","description":"light rain"}],"rain":{"1h":0.3}},
+{"dt":1717264800,"temp":15.1,"humidity":80,"clouds":30,"uvi":0.5,"visibility":10000,"wind_speed":3.1,"wind_deg":270,"pop":0.5,"weather":[{"id":521,"main":"Rain","description":"shower rain"}],"rain":{"1h":0.6}},
+{"dt":1717268400,"temp":14.3,"humidity":74,"clouds":10,"uvi":0.1,"visibility":10000,"wind_speed":2.4,"wind_deg":280,"pop":0.1,"weather":[{"id":800,"main":"Clear","description":"clear sky"}]}]}`
+
+// testAfternoonNow is ten minutes after testAfternoonBody was observed
+var testAfternoonNow = time.Date(2024, 6, 1, 16, 10, 0, 0, time.UTC)
+
+// testAfternoonWeather decodes testAfternoonBody
+func testAfternoonWeather(t testing.TB) WeatherData {
+	t.Helper()
+	var data WeatherData
+	if err := json.Unmarshal([]byte(testAfternoonBody), &data); err != nil {
+		t.Fatalf("decoding testAfternoonBody: %v", err)
+	}
+	return data
+}
+
+// newTestServer builds a Server around provider with the configuration defaults and a fake
+// clock fixed at testAfternoonNow
+func newTestServer(t testing.TB, provider WeatherProvider) *Server {
+	t.Helper()
+	cfg := Config{
+		BatchConcurrency:    8,
+		HeatmapConcurrency:  8,
+		HeatmapResolution:   0.05,
+		MaxHeatmapCells:     500,
+		MaxDataAge:          30 * time.Minute,
+		LikelihoodModel:     defaultLikelihoodModel,
+		LikelihoodThreshold: 0.1,
+		Weights:             defaultLikelihoodWeights,
+	}
+	model, err := newLikelihoodModel(cfg)
+	if err != nil {
+		t.Fatalf("newLikelihoodModel: %v", err)
+	}
+	return &Server{config: cfg, provider: provider, clock: newFakeClock(testAfternoonNow), model: model}
+}
+
+// serve runs handler against a GET of target and returns the recorded response
+func serve(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// Snapshot modes for upstream responses
+const (
+	snapshotOff    = ""
+	snapshotRecord = "record"
+	snapshotReplay = "replay"
+)
+
+// replayAPIKey stands in for an API key in replay mode, where no request leaves the process
+const replayAPIKey = "replay"
+
+// snapshotTransport records raw upstream response bodies to disk, or replays them in place
+// of the network. Files are named after the request path and query with the API key left
+// out, so a recording made with one key replays with any other, or with none.
+type snapshotTransport struct {
+	mode string
+	dir  string
+	next http.RoundTripper
+}
+
+// newSnapshotTransport creates a transport in mode over dir, sending live requests to next
+func newSnapshotTransport(mode, dir string, next http.RoundTripper) (*snapshotTransport, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating snapshot directory: %w", err)
+	}
+	return &snapshotTransport{mode: mode, dir: dir, next: next}, nil
+}
+
+// RoundTrip serves the request from a snapshot when replaying, otherwise makes it and, when
+// recording, saves a successful response's body
+func (t *snapshotTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	file := filepath.Join(t.dir, snapshotName(req))
+	if t.mode == snapshotReplay {
+		body, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("no snapshot to replay: %w", err)
+		}
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Status:        "200 OK",
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"application/json"}},
+			Body:          io.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	// A failed write only loses the recording, so the live response is still returned
+	if err := os.WriteFile(file, body, 0o644); err != nil {
+		log.FromContext(req.Context()).Warn("Error recording upstream snapshot", "file", file, "error", err)
+	}
+	return resp, nil
+}
+
+// snapshotName derives a readable file name from the request, such as
+// onecall_exclude-minutely_2Cdaily_2Calerts_lat-51.500000_lon--0.120000_units-metric.json
+func snapshotName(req *http.Request) string {
+	query := req.URL.Query()
+	query.Del("appid")
+	name := path.Base(req.URL.Path) + "_" + query.Encode()
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		case r == '=':
+			return '-'
+		default:
+			return '_'
+		}
+	}, name) + ".json"
+}
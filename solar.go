@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// maxRainbowSolarAltitude is the highest sun angle, in degrees, at which a primary
+// rainbow can still appear above the horizon
+const maxRainbowSolarAltitude = 42.0
+
+// solarAltitude returns the sun's altitude above the horizon in degrees for an
+// observer at lat/lon at time t, using the NOAA solar position equations
+func solarAltitude(lat, lon float64, t time.Time) float64 {
+	altitude, _ := solarPosition(lat, lon, t)
+	return altitude
+}
+
+// solarAzimuth returns the sun's compass bearing in degrees clockwise from true north
+func solarAzimuth(lat, lon float64, t time.Time) float64 {
+	_, azimuth := solarPosition(lat, lon, t)
+	return azimuth
+}
+
+// solarPosition returns the sun's altitude and azimuth in degrees. Azimuth is
+// measured clockwise from true north.
+func solarPosition(lat, lon float64, t time.Time) (altitude, azimuth float64) {
+	t = t.UTC()
+	julianDay := float64(t.Unix())/86400 + 2440587.5
+	jc := (julianDay - 2451545) / 36525 // Julian centuries since J2000
+
+	meanLong := math.Mod(280.46646+jc*(36000.76983+jc*0.0003032), 360)
+	meanAnom := 357.52911 + jc*(35999.05029-0.0001537*jc)
+	eccent := 0.016708634 - jc*(0.000042037+0.0000001267*jc)
+
+	eqCenter := sinDeg(meanAnom)*(1.914602-jc*(0.004817+0.000014*jc)) +
+		sinDeg(2*meanAnom)*(0.019993-0.000101*jc) +
+		sinDeg(3*meanAnom)*0.000289
+	trueLong := meanLong + eqCenter
+	omega := 125.04 - 1934.136*jc
+	appLong := trueLong - 0.00569 - 0.00478*sinDeg(omega)
+
+	meanObliq := 23 + (26+(21.448-jc*(46.815+jc*(0.00059-jc*0.001813)))/60)/60
+	obliq := meanObliq + 0.00256*cosDeg(omega)
+	declination := asinDeg(sinDeg(obliq) * sinDeg(appLong))
+
+	y := math.Pow(math.Tan(degToRad(obliq/2)), 2)
+	eqTime := 4 * radToDeg(y*sinDeg(2*meanLong)-
+		2*eccent*sinDeg(meanAnom)+
+		4*eccent*y*sinDeg(meanAnom)*cosDeg(2*meanLong)-
+		0.5*y*y*sinDeg(4*meanLong)-
+		1.25*eccent*eccent*sinDeg(2*meanAnom)) // minutes
+
+	minutes := float64(t.Hour()*60+t.Minute()) + float64(t.Second())/60
+	trueSolarTime := math.Mod(minutes+eqTime+4*lon, 1440)
+	if trueSolarTime < 0 {
+		trueSolarTime += 1440
+	}
+	hourAngle := trueSolarTime/4 - 180
+
+	cosZenith := sinDeg(lat)*sinDeg(declination) + cosDeg(lat)*cosDeg(declination)*cosDeg(hourAngle)
+	zenith := radToDeg(math.Acos(clamp(cosZenith, -1, 1)))
+	altitude = 90 - zenith
+
+	sinZenith := sinDeg(zenith)
+	if math.Abs(cosDeg(lat)*sinZenith) < 1e-9 {
+		// Sun directly overhead or observer at a pole; azimuth is undefined
+		return altitude, 180
+	}
+	cosAz := (sinDeg(lat)*cosDeg(zenith) - sinDeg(declination)) / (cosDeg(lat) * sinZenith)
+	az := radToDeg(math.Acos(clamp(cosAz, -1, 1)))
+	if hourAngle > 0 {
+		azimuth = math.Mod(az+180, 360)
+	} else {
+		azimuth = math.Mod(540-az, 360)
+	}
+	return altitude, azimuth
+}
+
+// solarFactor scales likelihood by sun altitude: zero below the horizon or above
+// maxRainbowSolarAltitude, peaking as the sun approaches the horizon
+func solarFactor(altitude float64) float64 {
+	if altitude <= 0 || altitude >= maxRainbowSolarAltitude {
+		return 0
+	}
+	return 1 - altitude/maxRainbowSolarAltitude
+}
+
+// antisolarBearing returns the bearing directly opposite the sun, which is where
+// the center of a rainbow lies
+func antisolarBearing(lat, lon float64, t time.Time) float64 {
+	return math.Mod(solarAzimuth(lat, lon, t)+180, 360)
+}
+
+// cardinalDirections are the 16 compass points, starting at north and moving clockwise
+var cardinalDirections = []string{
+	"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE",
+	"S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW",
+}
+
+// cardinalDirection converts a bearing in degrees to the nearest 16-point compass label
+func cardinalDirection(bearing float64) string {
+	bearing = math.Mod(bearing, 360)
+	if bearing < 0 {
+		bearing += 360
+	}
+	index := int(math.Round(bearing/22.5)) % len(cardinalDirections)
+	return cardinalDirections[index]
+}
+
+func degToRad(d float64) float64 { return d * math.Pi / 180 }
+func radToDeg(r float64) float64 { return r * 180 / math.Pi }
+func sinDeg(d float64) float64   { return math.Sin(degToRad(d)) }
+func cosDeg(d float64) float64   { return math.Cos(degToRad(d)) }
+func asinDeg(x float64) float64  { return radToDeg(math.Asin(x)) }
+
+// clamp limits v to the range [lo, hi]
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(v, hi))
+}
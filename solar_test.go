@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// angleBetween is the smallest difference between two bearings in degrees
+func angleBetween(a, b float64) float64 {
+	d := math.Abs(math.Mod(a-b, 360))
+	return math.Min(d, 360-d)
+}
+
+func TestSolarAzimuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		lat, lon float64
+		at       time.Time
+		want     float64
+	}{
+		// At solar noon the sun is due south north of the tropics and due north south of them
+		{name: "noon in the north", lat: 40, lon: 0, at: time.Date(2024, 3, 20, 12, 7, 0, 0, time.UTC), want: 180},
+		{name: "noon in the south", lat: -35, lon: 0, at: time.Date(2024, 3, 20, 12, 7, 0, 0, time.UTC), want: 0},
+		// Near the equinox the sun rises almost due east and sets almost due west
+		{name: "equinox morning", lat: 0, lon: 0, at: time.Date(2024, 3, 20, 7, 0, 0, 0, time.UTC), want: 90},
+		{name: "equinox evening", lat: 0, lon: 0, at: time.Date(2024, 3, 20, 17, 15, 0, 0, time.UTC), want: 270},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := solarAzimuth(tt.lat, tt.lon, tt.at)
+			if angleBetween(got, tt.want) > 3 {
+				t.Errorf("solarAzimuth = %.1f, want about %.0f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAntisolarBearingOpposesTheSun(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, place := range []Coordinates{{Lat: 51.5, Lon: -0.12}, {Lat: -33.9, Lon: 151.2}, {Lat: 64.1, Lon: -21.9}} {
+		for hour := range 24 {
+			at := start.Add(time.Duration(hour) * time.Hour)
+			sun := solarAzimuth(place.Lat, place.Lon, at)
+			bearing := antisolarBearing(place.Lat, place.Lon, at)
+			if bearing < 0 || bearing >= 360 {
+				t.Fatalf("%v at %v: bearing %.1f outside [0, 360)", place, at, bearing)
+			}
+			if d := angleBetween(bearing, sun); math.Abs(d-180) > 1e-9 {
+				t.Errorf("%v at %v: bearing %.1f is %.1f° from the sun at %.1f, want 180°", place, at, bearing, d, sun)
+			}
+		}
+	}
+}
+
+func TestCardinalDirection(t *testing.T) {
+	tests := []struct {
+		bearing float64
+		want    string
+	}{
+		{0, "N"}, {11.2, "N"}, {11.3, "NNE"}, {90, "E"}, {180, "S"}, {270, "W"},
+		{348.7, "NNW"}, {348.8, "N"}, {359.9, "N"}, {360, "N"}, {-90, "W"}, {450, "E"},
+	}
+	for _, tt := range tests {
+		if got := cardinalDirection(tt.bearing); got != tt.want {
+			t.Errorf("cardinalDirection(%v) = %q, want %q", tt.bearing, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+// owmDataSource credits OpenWeatherMap, as its terms require, on responses built from its data
+const owmDataSource = "OpenWeatherMap One Call 3.0"
+
+// sourceDescriber is implemented by providers that can name the service their data comes
+// from, so responses credit whichever backend is in use
+type sourceDescriber interface {
+	dataSource() string
+}
+
+// dataSource names the One Call API
+func (p *OpenWeatherMapProvider) dataSource() string {
+	return owmDataSource
+}
+
+// dataSource names the wrapped provider's source, since cached data still came from it
+func (c *CachingProvider) dataSource() string {
+	describer, ok := c.next.(sourceDescriber)
+	if !ok {
+		return ""
+	}
+	return describer.dataSource()
+}
+
+// dataSource returns the source of the server's weather data, or "" if the provider doesn't say
+func (s *Server) dataSource() string {
+	describer, ok := s.provider.(sourceDescriber)
+	if !ok {
+		return ""
+	}
+	return describer.dataSource()
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gorilla/websocket"
+)
+
+// Timing for WebSocket prediction streams
+const (
+	// streamWriteTimeout bounds how long a single message may take to send
+	streamWriteTimeout = 10 * time.Second
+	// streamPongTimeout is how long a client may go without answering a ping
+	streamPongTimeout = time.Minute
+	// streamPingInterval keeps idle connections alive and detects dead clients
+	streamPingInterval = streamPongTimeout * 9 / 10
+	// streamReadLimit caps incoming messages; clients have nothing to say beyond control frames
+	streamReadLimit = 512
+)
+
+// refreshNotifier is implemented by providers that can announce when fresh weather for a
+// coordinate has been fetched
+type refreshNotifier interface {
+	refreshed(lat, lon float64, opts FetchOptions) (ch <-chan struct{}, release func())
+}
+
+// streamHub bounds the number of open WebSocket prediction streams and closes them on shutdown
+type streamHub struct {
+	upgrader websocket.Upgrader
+	slots    chan struct{}
+	interval time.Duration
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// newStreamHub creates a hub allowing cfg.MaxStreamSubscribers streams. Upgrades are accepted
+// from the same origin or any origin the CORS policy allows.
+func newStreamHub(cfg Config, cors *corsPolicy) *streamHub {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &streamHub{
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				origin := r.Header.Get("Origin")
+				if origin == "" {
+					return true
+				}
+				if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+					return true
+				}
+				return cors.allowed(origin)
+			},
+		},
+		slots:    make(chan struct{}, cfg.MaxStreamSubscribers),
+		interval: cfg.StreamInterval,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// acquire reserves a subscriber slot, reporting false when every slot is taken
+func (h *streamHub) acquire() bool {
+	select {
+	case h.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release frees a slot reserved by acquire
+func (h *streamHub) release() {
+	<-h.slots
+}
+
+// close ends every open stream. http.Server.Shutdown doesn't track hijacked connections,
+// so this is registered to run when shutdown begins.
+func (h *streamHub) close() {
+	h.cancel()
+}
+
+// handlePredictionStream upgrades to a WebSocket and pushes a RainbowPrediction for the
+// coordinate on connect, every stream interval, and whenever its cached weather is refreshed
+func (s *Server) handlePredictionStream(w http.ResponseWriter, r *http.Request) {
+	logger := log.FromContext(r.Context())
+	coords, err := coordinatesParam(r)
+	if err != nil {
+		logger.Error("Invalid coordinates", "error", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	lat, lon := coords.Lat, coords.Lon
+	opts, err := s.parsePredictOptions(r)
+	if err != nil {
+		logger.Error("Invalid prediction options", "error", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !s.streams.acquire() {
+		logger.Warn("Prediction stream limit reached", "max", cap(s.streams.slots))
+		writeJSONError(w, http.StatusServiceUnavailable, "Too many open prediction streams; try again later")
+		return
+	}
+	defer s.streams.release()
+
+	conn, err := s.streams.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied with an HTTP error
+		logger.Error("WebSocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	predictionRequests.Inc()
+	logger.Info("Prediction stream opened", "latitude", lat, "longitude", lon, "options", opts)
+
+	// The stream ends when the client goes away or the server shuts down
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stop := context.AfterFunc(s.streams.ctx, cancel)
+	defer stop()
+	go func() {
+		readUntilClosed(conn)
+		cancel()
+	}()
+
+	s.streamPredictions(ctx, conn, lat, lon, opts)
+
+	if s.streams.ctx.Err() != nil {
+		deadline := time.Now().Add(streamWriteTimeout)
+		msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+		if err := conn.WriteControl(websocket.CloseMessage, msg, deadline); err != nil {
+			logger.Debug("Error sending close frame", "error", err)
+		}
+	}
+	logger.Info("Prediction stream closed")
+}
+
+// streamPredictions sends predictions until ctx is canceled or a write fails
+func (s *Server) streamPredictions(ctx context.Context, conn *websocket.Conn, lat, lon float64, opts PredictOptions) {
+	logger := log.FromContext(ctx)
+	ticker := time.NewTicker(s.streams.interval)
+	defer ticker.Stop()
+	pinger := time.NewTicker(streamPingInterval)
+	defer pinger.Stop()
+	notifier, _ := s.provider.(refreshNotifier)
+	// release drops the current refresh subscription, including when the stream ends
+	release := func() {}
+	defer func() { release() }()
+
+	for {
+		var message any
+		prediction, err := s.predict(ctx, lat, lon, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error("Error fetching weather data", "error", err)
+			message = ErrorResponse{Error: fmt.Sprintf("Error fetching weather data: %v", err), Status: upstreamErrorStatus(err), Upstream: upstreamName}
+		} else {
+			message = prediction
+		}
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if err := conn.WriteJSON(message); err != nil {
+			logger.Debug("Error writing to prediction stream", "error", err)
+			return
+		}
+
+		// Subscribe after predicting so our own cache fill doesn't trigger an immediate resend
+		release()
+		var refreshed <-chan struct{}
+		if notifier != nil {
+			refreshed, release = notifier.refreshed(lat, lon, FetchOptions{Units: opts.Units, Exclude: hourlyExclude})
+		}
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				break wait
+			case <-refreshed:
+				logger.Debug("Cached weather refreshed; pushing update")
+				break wait
+			case <-pinger.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+					logger.Debug("Error pinging prediction stream", "error", err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// readUntilClosed discards client messages and returns once the connection is closed or
+// stops answering pings
+func readUntilClosed(conn *websocket.Conn) {
+	conn.SetReadLimit(streamReadLimit)
+	conn.SetReadDeadline(time.Now().Add(streamPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(streamPongTimeout))
+	})
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// warmJitter is the fraction by which each warming interval is randomly lengthened or
+// shortened, so several instances warming the same locations don't hit the upstream in step
+const warmJitter = 0.1
+
+// cacheWarmer keeps the weather for a fixed set of locations in the cache, refetching it
+// on a jittered interval shorter than the cache TTL so their predictions are always warm
+type cacheWarmer struct {
+	cache     *CachingProvider
+	locations []Coordinates
+	units     []string
+	interval  time.Duration
+}
+
+// run warms every location straight away and then once per interval until ctx is done
+func (w *cacheWarmer) run(ctx context.Context) {
+	log.Info("Cache warmer started", "locations", len(w.locations), "units", w.units, "interval", w.interval)
+	for {
+		w.warm(ctx)
+		delay := time.Duration(float64(w.interval) * (1 + warmJitter*(2*rand.Float64()-1)))
+		if err := sleepContext(ctx, delay); err != nil {
+			log.Info("Cache warmer stopped")
+			return
+		}
+	}
+}
+
+// warm refreshes the cached weather of each location in each unit system
+func (w *cacheWarmer) warm(ctx context.Context) {
+	for _, loc := range w.locations {
+		for _, units := range w.units {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := w.cache.refresh(ctx, loc.Lat, loc.Lon, FetchOptions{Units: units, Exclude: hourlyExclude}); err != nil && ctx.Err() == nil {
+				log.Warn("Error warming weather cache", "lat", loc.Lat, "lon", loc.Lon, "units", units, "error", err)
+			}
+		}
+	}
+}
+
+// parseWarmLocations reads a semicolon-separated list of lat,lon pairs
+func parseWarmLocations(v string) ([]Coordinates, error) {
+	var locations []Coordinates
+	for _, item := range strings.Split(v, ";") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		lat, lon, ok := strings.Cut(item, ",")
+		if !ok {
+			return nil, fmt.Errorf("location %q must be lat,lon", item)
+		}
+		c, err := ParseCoordinates(lat, lon)
+		if err != nil {
+			return nil, fmt.Errorf("location %q: %w", item, err)
+		}
+		locations = append(locations, c)
+	}
+	return locations, nil
+}